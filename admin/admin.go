@@ -0,0 +1,182 @@
+// Package admin 提供运维使用的 HTTP 接口，和 thrift 服务使用不同的端口：
+//
+//	/health       进程存活
+//	/ready        依赖和监听都正常，可以接收流量
+//	/config       当前生效的配置，标记为 secret 的字段会被隐藏
+//	/version      编译信息
+//	/runtime      goroutine、内存、GC 等运行时信息
+//	/metrics      Prometheus 文本格式的监控指标
+//	/status/      限流等组件的运行状态
+//	/debug/pprof/ 性能分析
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"php-thrift-go-server/metrics"
+	"php-thrift-go-server/util"
+)
+
+// 编译信息，编译时通过 -ldflags "-X php-thrift-go-server/admin.Version=..." 设置。
+var (
+	Version   = "dev"
+	GitCommit = ""
+	BuildTime = ""
+)
+
+var startTime = time.Now()
+
+// defaultCheckTimeout 是 /ready 所有检查加起来的默认超时时间。
+const defaultCheckTimeout = time.Second
+
+// Check 是一项就绪检查，返回 nil 表示通过。ctx 带有超时时间，Check 应该在 ctx 结束时尽快返回。
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Status 是一个组件的运行状态，/status/Name 返回 Status() 的结果。
+type Status struct {
+	Name   string
+	Status func() interface{}
+}
+
+// Options 是 admin 接口的参数。
+type Options struct {
+	Config       interface{}   // /config 返回的配置。
+	Checks       []Check       // /ready 依次执行的检查，全部通过才算就绪。
+	CheckTimeout time.Duration // /ready 所有检查加起来的超时时间，默认 1s，依赖卡住时返回未就绪。
+	Statuses     []Status      // /status/ 返回所有组件的状态，/status/Name 只返回一个。
+
+	Metrics *metrics.Registry // /metrics 输出的指标，为 nil 时使用 metrics.Default。
+}
+
+// NewHandler 创建 admin 接口的 http.Handler。
+func NewHandler(opts Options) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+	})
+
+	if opts.CheckTimeout <= 0 {
+		opts.CheckTimeout = defaultCheckTimeout
+	}
+
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), opts.CheckTimeout)
+		defer cancel()
+
+		status, code := "ok", http.StatusOK
+		checks := make(map[string]string, len(opts.Checks))
+
+		for _, check := range opts.Checks {
+			if err := runCheck(ctx, check); err != nil {
+				checks[check.Name] = err.Error()
+				status, code = "not ready", http.StatusServiceUnavailable
+			} else {
+				checks[check.Name] = "ok"
+			}
+		}
+
+		writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+	})
+
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Redact(opts.Config))
+	})
+
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"version":    Version,
+			"git_commit": GitCommit,
+			"build_time": BuildTime,
+			"go_version": runtime.Version(),
+			"start_time": startTime.Format(time.RFC3339),
+		})
+	})
+
+	mux.HandleFunc("/runtime", func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"pid":          os.Getpid(),
+			"uptime":       time.Since(startTime).String(),
+			"goroutines":   runtime.NumGoroutine(),
+			"num_cpu":      runtime.NumCPU(),
+			"gomaxprocs":   runtime.GOMAXPROCS(0),
+			"heap_alloc":   mem.HeapAlloc,
+			"heap_objects": mem.HeapObjects,
+			"sys":          mem.Sys,
+			"num_gc":       mem.NumGC,
+			"pause_total":  time.Duration(mem.PauseTotalNs).String(),
+		})
+	})
+
+	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/status/")
+		statuses := make(map[string]interface{}, len(opts.Statuses))
+
+		for _, status := range opts.Statuses {
+			if name == status.Name {
+				writeJSON(w, http.StatusOK, status.Status())
+				return
+			}
+
+			statuses[status.Name] = status.Status()
+		}
+
+		if name != "" {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "unknown status " + name})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, statuses)
+	})
+
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.Default
+	}
+
+	mux.Handle("/metrics", opts.Metrics.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(util.JsonString(v)))
+}
+
+// runCheck 执行一项检查，ctx 结束时不再等待检查返回，检查在后台继续执行。
+func runCheck(ctx context.Context, check Check) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("skipped: %v", err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- check.Check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timeout: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"php-thrift-go-server/conf"
+	"php-thrift-go-server/util"
+)
+
+type testConf struct {
+	Addr     string            `toml:"addr"`
+	Password string            `toml:"password" secret:"true"`
+	Empty    string            `toml:"empty" secret:"true"`
+	Apps     map[string]string `toml:"apps"`
+	Nested   struct {
+		Token string `toml:"token" secret:"true"`
+	} `toml:"nested"`
+}
+
+func TestRedact(t *testing.T) {
+	c := testConf{Addr: "localhost:8999", Password: "pass", Apps: map[string]string{"a": "b"}}
+	c.Nested.Token = "token"
+
+	m := Redact(c).(map[string]interface{})
+
+	if m["addr"] != "localhost:8999" || m["password"] != redacted || m["empty"] != "" {
+		t.Fatalf("invalid redacted config. [actual:%v]", m)
+	}
+
+	if m["nested"].(map[string]interface{})["token"] != redacted || m["apps"].(map[string]interface{})["a"] != "b" {
+		t.Fatalf("nested secret must be redacted. [actual:%v]", m)
+	}
+	// 启动时打印的服务配置中调用方的 secret 也要替换掉。
+	config := conf.DefaultConfig()
+	config.AuthConf.Apps = map[string]conf.AppConf{"php_web": {Secret: "app-secret"}}
+
+	if out := util.JsonString(Redact(config)); strings.Contains(out, "app-secret") {
+		t.Fatalf("app secret must be redacted. [actual:%v]", out)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var redisErr error
+	handler := NewHandler(Options{
+		Config: testConf{Password: "pass"},
+		Checks: []Check{
+			{Name: "redis", Check: func(ctx context.Context) error { return redisErr }},
+		},
+		Statuses: []Status{
+			{Name: "ratelimit", Status: func() interface{} { return map[string]int{"rules": 2} }},
+		},
+	})
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	if code, _ := get("/health"); code != http.StatusOK {
+		t.Fatalf("health must be ok. [code:%v]", code)
+	}
+
+	if code, body := get("/ready"); code != http.StatusOK {
+		t.Fatalf("ready must be ok. [code:%v] [body:%v]", code, body)
+	}
+
+	redisErr = errors.New("connection refused")
+
+	if code, body := get("/ready"); code != http.StatusServiceUnavailable || !strings.Contains(body, "connection refused") {
+		t.Fatalf("ready must fail. [code:%v] [body:%v]", code, body)
+	}
+
+	if _, body := get("/config"); strings.Contains(body, `"pass"`) {
+		t.Fatalf("secret must not be shown. [body:%v]", body)
+	}
+
+	if code, body := get("/version"); code != http.StatusOK || !strings.Contains(body, Version) {
+		t.Fatalf("invalid version. [code:%v] [body:%v]", code, body)
+	}
+
+	if code, body := get("/status/ratelimit"); code != http.StatusOK || body != `{"rules":2}` {
+		t.Fatalf("invalid status. [code:%v] [body:%v]", code, body)
+	}
+
+	if code, body := get("/status/"); code != http.StatusOK || body != `{"ratelimit":{"rules":2}}` {
+		t.Fatalf("invalid statuses. [code:%v] [body:%v]", code, body)
+	}
+
+	if code, _ := get("/status/unknown"); code != http.StatusNotFound {
+		t.Fatalf("unknown status must not be found. [code:%v]", code)
+	}
+}
+
+func TestReadyTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	handler := NewHandler(Options{
+		Checks: []Check{
+			{Name: "redis", Check: func(ctx context.Context) error { <-block; return nil }},
+			{Name: "thrift", Check: func(ctx context.Context) error { return nil }},
+		},
+		CheckTimeout: 50 * time.Millisecond,
+	})
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("ready must return after check timeout. [elapsed:%v]", elapsed)
+	}
+
+	if body := w.Body.String(); w.Code != http.StatusServiceUnavailable || !strings.Contains(body, "timeout") || !strings.Contains(body, "skipped") {
+		t.Fatalf("hanging check must make ready fail. [code:%v] [body:%v]", w.Code, body)
+	}
+}
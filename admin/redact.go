@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// redacted 是 secret 字段在 /config 中显示的值。
+const redacted = "******"
+
+// Redact 把配置转换成 map，key 使用 toml tag 中的名字。
+// 带有 `secret:"true"` tag 的字段不为空时替换成 ******，这样 /config 不会泄露密码和密钥。
+func Redact(v interface{}) interface{} {
+	return redact(reflect.ValueOf(v))
+}
+
+func redact(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return redact(v.Elem())
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		t := v.Type()
+
+		for i := 0; i < v.NumField(); i++ {
+			field := t.Field(i)
+
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := field.Name
+
+			if tag := strings.Split(field.Tag.Get("toml"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+
+			if field.Tag.Get("secret") == "true" {
+				if !isZero(v.Field(i)) {
+					m[name] = redacted
+				} else {
+					m[name] = ""
+				}
+
+				continue
+			}
+
+			m[name] = redact(v.Field(i))
+		}
+
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+
+		s := make([]interface{}, v.Len())
+
+		for i := range s {
+			s[i] = redact(v.Index(i))
+		}
+
+		return s
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+
+		m := make(map[string]interface{}, v.Len())
+
+		for _, key := range v.MapKeys() {
+			m[toString(key)] = redact(v.MapIndex(key))
+		}
+
+		return m
+	}
+
+	return v.Interface()
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+func toString(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,207 @@
+// Package auth 实现调用方的 HMAC 签名认证：每个调用方有自己的 app id 和 secret，
+// 用 secret 对方法名、时间戳、nonce、trace 和请求内容做 HMAC-SHA256 签名，
+// 服务端校验签名，拒绝时间窗口之外的请求和时间窗口之内重复的 nonce。
+package auth
+
+import (
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissing       = errors.New("missing signature")
+	ErrUnknownApp    = errors.New("unknown app id")
+	ErrExpired       = errors.New("timestamp out of window")
+	ErrBadSignature  = errors.New("signature mismatch")
+	ErrReplayed      = errors.New("nonce already used")
+	ErrTooManyNonces = errors.New("too many signed requests in window")
+)
+
+// DefaultMaxQPS 是没有配置 MaxQPS 的调用方每秒最多的签名请求数。
+const DefaultMaxQPS = 1000
+
+type appIDOfContext struct{}
+
+var (
+	keyAppID = appIDOfContext{}
+)
+
+// Message 是被签名的请求内容。
+type Message struct {
+	Method    string
+	Timestamp int64             // 签名时的 unix 时间戳，单位秒。
+	Nonce     string            // 每个请求随机生成，时间窗口内不能重复。
+	Trace     map[string]string // 请求中的 trace，包括 hintCode，改了 hintCode 就能把压测流量变成线上流量。
+	Payload   []byte            // 请求去掉 auth 和 trace 之后的 binary 序列化。
+}
+
+// Sign 返回 hex(HMAC-SHA256(secret, method + "\n" + timestamp + "\n" + nonce + "\n" + trace + "\n" + payload))，
+// trace 按 key 排序之后编码成 URL query，和 PHP 的 ksort 加 http_build_query 一致。
+func Sign(secret string, m Message) string {
+	trace := make(url.Values, len(m.Trace))
+
+	for k, v := range m.Trace {
+		trace.Set(k, v)
+	}
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(m.Method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(strconv.FormatInt(m.Timestamp, 10)))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(m.Nonce))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(trace.Encode()))
+	h.Write([]byte{'\n'})
+	h.Write(m.Payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// App 是一个调用方的认证配置。
+type App struct {
+	Secret string
+	MaxQPS int // 每秒最多多少个签名请求，nonce 缓存最多记住 2 × MaxQPS × window 个 nonce，为 0 时是 DefaultMaxQPS。
+}
+
+// Verifier 校验调用方的签名。
+type Verifier struct {
+	apps   map[string]*app
+	window time.Duration
+	now    func() time.Time
+}
+
+type app struct {
+	secret string
+	nonces *nonceCache
+}
+
+// NewVerifier 创建 Verifier，apps 的 key 是 app id。
+// 时间戳和服务端时间相差超过 window 的请求当作重放拒绝，window 之内重复的 nonce 也当作重放拒绝。
+// 每个调用方的 nonce 单独缓存，一个调用方超过 MaxQPS 不影响其他调用方。
+func NewVerifier(apps map[string]App, window time.Duration) *Verifier {
+	v := &Verifier{
+		apps:   make(map[string]*app, len(apps)),
+		window: window,
+		now:    time.Now,
+	}
+
+	for appID, a := range apps {
+		maxQPS := a.MaxQPS
+
+		if maxQPS <= 0 {
+			maxQPS = DefaultMaxQPS
+		}
+
+		// 时间戳在 [now-window, now+window] 之内都能通过，nonce 最多要记住 2 × window。
+		maxNonces := int(int64(maxQPS) * int64(2*window) / int64(time.Second))
+
+		if maxNonces < 1 {
+			maxNonces = 1
+		}
+
+		v.apps[appID] = &app{secret: a.Secret, nonces: newNonceCache(maxNonces)}
+	}
+
+	return v
+}
+
+// Verify 校验 appID 对请求的签名，签名不对时返回 ErrUnknownApp、ErrExpired 或者 ErrBadSignature，
+// nonce 在时间窗口内用过时返回 ErrReplayed。
+// 调用方在时间窗口内的请求超过 2 × MaxQPS × window 个时 nonce 缓存是满的，返回 ErrTooManyNonces，
+// 直到最早的 nonce 过期；不能忘掉还在窗口内的 nonce，否则就能重放。
+func (v *Verifier) Verify(appID string, m Message, signature string) error {
+	if appID == "" || signature == "" || m.Nonce == "" {
+		return ErrMissing
+	}
+
+	a, ok := v.apps[appID]
+
+	if !ok {
+		return ErrUnknownApp
+	}
+
+	now := v.now()
+
+	if diff := now.Sub(time.Unix(m.Timestamp, 0)); diff > v.window || diff < -v.window {
+		return ErrExpired
+	}
+
+	if !hmac.Equal([]byte(Sign(a.secret, m)), []byte(signature)) {
+		return ErrBadSignature
+	}
+
+	// 签名通过之后才记住 nonce，没有 secret 的人不能占满缓存。
+	return a.nonces.use(m.Nonce, time.Unix(m.Timestamp, 0).Add(v.window), now)
+}
+
+// nonceCache 记住一个调用方在时间窗口内用过的 nonce，过期之后时间戳已经在窗口之外，不用再记住。
+type nonceCache struct {
+	mu      sync.Mutex
+	max     int
+	expires map[string]time.Time
+	queue   nonceQueue
+}
+
+func newNonceCache(max int) *nonceCache {
+	return &nonceCache{max: max, expires: make(map[string]time.Time)}
+}
+
+func (c *nonceCache) use(nonce string, expireAt, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.queue) > 0 && !now.Before(c.queue[0].expireAt) {
+		delete(c.expires, heap.Pop(&c.queue).(nonceEntry).nonce)
+	}
+
+	if _, ok := c.expires[nonce]; ok {
+		return ErrReplayed
+	}
+
+	if len(c.expires) >= c.max {
+		return ErrTooManyNonces
+	}
+
+	c.expires[nonce] = expireAt
+	heap.Push(&c.queue, nonceEntry{nonce: nonce, expireAt: expireAt})
+	return nil
+}
+
+type nonceEntry struct {
+	nonce    string
+	expireAt time.Time
+}
+
+// nonceQueue 是按过期时间排序的最小堆。
+type nonceQueue []nonceEntry
+
+func (q nonceQueue) Len() int            { return len(q) }
+func (q nonceQueue) Less(i, j int) bool  { return q[i].expireAt.Before(q[j].expireAt) }
+func (q nonceQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nonceQueue) Push(x interface{}) { *q = append(*q, x.(nonceEntry)) }
+
+func (q *nonceQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// NewContext 返回一个带有认证过的调用方 app id 的 ctx。
+func NewContext(ctx context.Context, appID string) context.Context {
+	return context.WithValue(ctx, keyAppID, appID)
+}
+
+// AppID 返回 ctx 中认证过的调用方 app id，没有认证时返回空字符串。
+func AppID(ctx context.Context) string {
+	appID, _ := ctx.Value(keyAppID).(string)
+	return appID
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVerifier(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	v := NewVerifier(map[string]App{"php_web": {Secret: "secret"}}, 5*time.Minute)
+	v.now = func() time.Time {
+		return now
+	}
+
+	ts := now.Unix() - 60
+	m := Message{Method: "SetUsers", Timestamp: ts, Nonce: "n1", Trace: map[string]string{"hintCode": "1", "traceid": "t"}, Payload: []byte("request")}
+	signature := Sign("secret", m)
+
+	if err := v.Verify("php_web", m, signature); err != nil {
+		t.Fatalf("valid signature must be accepted. [err:%v]", err)
+	}
+
+	if err := v.Verify("php_web", m, signature); err != ErrReplayed {
+		t.Fatalf("reused nonce must be rejected. [err:%v]", err)
+	}
+
+	with := func(f func(m *Message)) Message {
+		copied := m
+		copied.Nonce = "n2"
+		f(&copied)
+		return copied
+	}
+
+	expired := func(ts int64) Message {
+		return with(func(m *Message) { m.Timestamp = ts })
+	}
+
+	cases := []struct {
+		appID     string
+		m         Message
+		signature string
+		err       error
+	}{
+		{"", m, signature, ErrMissing},
+		{"php_web", with(func(m *Message) { m.Nonce = "" }), signature, ErrMissing},
+		{"php_cron", m, signature, ErrUnknownApp},
+		{"php_web", expired(now.Unix() - 301), Sign("secret", expired(now.Unix()-301)), ErrExpired},
+		{"php_web", expired(now.Unix() + 301), Sign("secret", expired(now.Unix()+301)), ErrExpired},
+		{"php_web", with(func(m *Message) { m.Method = "GetUserByUserID" }), signature, ErrBadSignature},
+		{"php_web", with(func(m *Message) { m.Payload = []byte("tampered") }), signature, ErrBadSignature},
+		{"php_web", with(func(m *Message) { m.Timestamp++ }), signature, ErrBadSignature},
+		{"php_web", with(func(m *Message) { m.Trace = map[string]string{"hintCode": "2", "traceid": "t"} }), signature, ErrBadSignature},
+	}
+
+	for i, c := range cases {
+		if err := v.Verify(c.appID, c.m, c.signature); err != c.err {
+			t.Fatalf("invalid error. [case:%v] [expected:%v] [actual:%v]", i, c.err, err)
+		}
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	// 每个调用方最多记住 2 × 1 × 1 个 nonce。
+	v := NewVerifier(map[string]App{"php_web": {Secret: "secret", MaxQPS: 1}, "php_cron": {Secret: "cron"}}, time.Second)
+	v.now = func() time.Time {
+		return now
+	}
+
+	verify := func(appID, secret, nonce string) error {
+		m := Message{Method: "SetUsers", Timestamp: now.Unix(), Nonce: nonce}
+		return v.Verify(appID, m, Sign(secret, m))
+	}
+
+	if verify("php_web", "secret", "n1") != nil || verify("php_web", "secret", "n2") != nil {
+		t.Fatalf("new nonces must be accepted.")
+	}
+
+	// 窗口内的 nonce 不能被挤掉，否则就能重放。
+	if err := verify("php_web", "secret", "n3"); err != ErrTooManyNonces {
+		t.Fatalf("full cache must reject new nonce. [err:%v]", err)
+	}
+
+	// 一个调用方的缓存满了不影响其他调用方，不同调用方可以用同一个 nonce。
+	if err := verify("php_cron", "cron", "n1"); err != nil {
+		t.Fatalf("other app must not be affected. [err:%v]", err)
+	}
+
+	// 时间戳出了窗口之后 nonce 被清理，同一个 nonce 带新的时间戳也可以再用。
+	now = now.Add(time.Second)
+
+	if err := verify("php_web", "secret", "n3"); err != nil {
+		t.Fatalf("expired nonces must be pruned. [err:%v]", err)
+	}
+
+	if err := verify("php_web", "secret", "n1"); err != nil || len(v.apps["php_web"].nonces.expires) != 2 {
+		t.Fatalf("nonce must be usable again after window. [err:%v] [nonces:%v]", err, len(v.apps["php_web"].nonces.expires))
+	}
+}
+
+func TestContext(t *testing.T) {
+	if AppID(context.Background()) != "" {
+		t.Fatalf("app id must be empty without auth.")
+	}
+
+	if appID := AppID(NewContext(context.Background(), "php_web")); appID != "php_web" {
+		t.Fatalf("invalid app id. [actual:%v]", appID)
+	}
+}
@@ -0,0 +1,179 @@
+// Package authz 按调用方的角色控制每个 RPC 方法的访问权限。
+// 调用方通过来源 IP/CIDR、TLS 客户端证书身份或者签名认证过的 app id 匹配角色，一个调用方可以有多个角色，
+// 每个方法配置允许调用的角色，方法按 service:method 区分，没有配置的方法不允许任何人调用。
+package authz
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Members 描述哪些调用方属于一个角色，满足任意一条即可。
+type Members struct {
+	CIDRs      []string // 来源 IP 或者 CIDR，比如 10.0.0.1、10.0.0.0/8。
+	Identities []string // TLS 客户端证书身份，见 server.ConnInfo.ClientIdentity。
+	AppIDs     []string // 签名认证过的 app id。
+}
+
+// Identity 是调用方的身份，不知道的字段为空。
+type Identity struct {
+	IP          net.IP
+	TLSIdentity string
+	AppID       string
+}
+
+type role struct {
+	name       string
+	nets       []*net.IPNet
+	identities map[string]bool
+	appIDs     map[string]bool
+}
+
+func (r *role) match(id Identity) bool {
+	if id.TLSIdentity != "" && r.identities[id.TLSIdentity] {
+		return true
+	}
+
+	if id.AppID != "" && r.appIDs[id.AppID] {
+		return true
+	}
+
+	if id.IP != nil {
+		for _, n := range r.nets {
+			if n.Contains(id.IP) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Policy 是角色的定义和每个方法允许的角色，创建之后只读，可以在多个 goroutine 中使用。
+type Policy struct {
+	roles   []*role
+	methods map[string]map[string]bool // key 是 service:method。
+}
+
+// NewPolicy 创建 Policy，roles 的 key 是角色名，methods 的 key 是 service:method，value 是允许调用的角色。
+// 只写方法名时是 defaultService 的方法，其他 service 的同名方法不受影响。
+// CIDR 格式错误、方法引用了没有定义的角色或者同一个方法配置了两次时返回错误。
+func NewPolicy(roles map[string]Members, methods map[string][]string, defaultService string) (*Policy, error) {
+	p := &Policy{methods: make(map[string]map[string]bool, len(methods))}
+	names := make([]string, 0, len(roles))
+
+	for name := range roles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		members := roles[name]
+		r := &role{name: name, identities: toSet(members.Identities), appIDs: toSet(members.AppIDs)}
+
+		for _, cidr := range members.CIDRs {
+			n, err := parseCIDR(cidr)
+
+			if err != nil {
+				return nil, fmt.Errorf("role %v: %v", name, err)
+			}
+
+			r.nets = append(r.nets, n)
+		}
+
+		p.roles = append(p.roles, r)
+	}
+
+	for method, allowed := range methods {
+		for _, name := range allowed {
+			if _, ok := roles[name]; !ok {
+				return nil, fmt.Errorf("method %v: undefined role %v", method, name)
+			}
+		}
+
+		key := method
+
+		if !strings.Contains(method, ":") {
+			key = methodKey(defaultService, method)
+		}
+
+		if _, ok := p.methods[key]; ok {
+			return nil, fmt.Errorf("method %v: duplicate rule for %v", method, key)
+		}
+
+		p.methods[key] = toSet(allowed)
+	}
+
+	return p, nil
+}
+
+// Roles 返回调用方的所有角色，按角色名排序。
+func (p *Policy) Roles(id Identity) []string {
+	var roles []string
+
+	for _, r := range p.roles {
+		if r.match(id) {
+			roles = append(roles, r.name)
+		}
+	}
+
+	return roles
+}
+
+// Allow 判断调用方能否调用 service 的 method，同时返回调用方的角色用于审计日志。
+func (p *Policy) Allow(service, method string, id Identity) (roles []string, ok bool) {
+	roles = p.Roles(id)
+	allowed := p.methods[methodKey(service, method)]
+
+	for _, name := range roles {
+		if allowed[name] {
+			return roles, true
+		}
+	}
+
+	return roles, false
+}
+
+func methodKey(service, method string) string {
+	return service + ":" + method
+}
+
+// parseCIDR 解析 CIDR，单个 IP 当作 /32 或者 /128。
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip %q", s)
+		}
+
+		bits := 8 * net.IPv6len
+
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %q", s)
+	}
+
+	return n, nil
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
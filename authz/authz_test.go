@@ -0,0 +1,64 @@
+package authz
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	p, err := NewPolicy(map[string]Members{
+		"reader": {CIDRs: []string{"10.0.0.0/8", "192.168.1.1"}},
+		"writer": {Identities: []string{"user-sync.backend"}, AppIDs: []string{"user_sync_job"}},
+		"admin":  {AppIDs: []string{"ops"}},
+	}, map[string][]string{
+		"GetUserByUserID":     {"reader", "writer", "admin"},
+		"SetUsers":            {"writer", "admin"},
+		"Admin_Svr:SetUsers":  {"admin"},
+		"Php_Go_Svr:DelUsers": {"admin"},
+	}, "Php_Go_Svr")
+
+	if err != nil {
+		t.Fatalf("fail to create policy. [err:%v]", err)
+	}
+
+	cases := []struct {
+		service string
+		method  string
+		id      Identity
+		roles   []string
+		ok      bool
+	}{
+		{"Php_Go_Svr", "GetUserByUserID", Identity{IP: net.ParseIP("10.1.2.3")}, []string{"reader"}, true},
+		{"Php_Go_Svr", "SetUsers", Identity{IP: net.ParseIP("10.1.2.3")}, []string{"reader"}, false},
+		{"Php_Go_Svr", "GetUserByUserID", Identity{IP: net.ParseIP("192.168.1.2")}, nil, false},
+		{"Php_Go_Svr", "SetUsers", Identity{IP: net.ParseIP("10.1.2.3"), TLSIdentity: "user-sync.backend"}, []string{"reader", "writer"}, true},
+		{"Php_Go_Svr", "SetUsers", Identity{AppID: "user_sync_job"}, []string{"writer"}, true},
+		{"Php_Go_Svr", "Unknown", Identity{AppID: "ops"}, []string{"admin"}, false},
+		{"Php_Go_Svr", "DelUsers", Identity{AppID: "ops"}, []string{"admin"}, true},
+		// 只写方法名的规则只对默认 service 生效。
+		{"Admin_Svr", "GetUserByUserID", Identity{AppID: "ops"}, []string{"admin"}, false},
+		{"Admin_Svr", "SetUsers", Identity{AppID: "user_sync_job"}, []string{"writer"}, false},
+		{"Admin_Svr", "SetUsers", Identity{AppID: "ops"}, []string{"admin"}, true},
+	}
+
+	for i, c := range cases {
+		roles, ok := p.Allow(c.service, c.method, c.id)
+
+		if ok != c.ok || !reflect.DeepEqual(roles, c.roles) {
+			t.Fatalf("invalid result. [case:%v] [roles:%v] [ok:%v]", i, roles, ok)
+		}
+	}
+
+	if _, err := NewPolicy(map[string]Members{"reader": {CIDRs: []string{"10.0.0.0/33"}}}, nil, "Php_Go_Svr"); err == nil {
+		t.Fatalf("invalid cidr must be rejected.")
+	}
+
+	if _, err := NewPolicy(nil, map[string][]string{"SetUsers": {"writer"}}, "Php_Go_Svr"); err == nil {
+		t.Fatalf("undefined role must be rejected.")
+	}
+
+	if _, err := NewPolicy(map[string]Members{"writer": {}}, map[string][]string{"SetUsers": {"writer"}, "Php_Go_Svr:SetUsers": {"writer"}}, "Php_Go_Svr"); err == nil {
+		t.Fatalf("duplicate method must be rejected.")
+	}
+}
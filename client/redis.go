@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"php-thrift-go-server/conf"
@@ -8,7 +9,13 @@ import (
 
 var RedisClient redis.Client
 
-func InitRedis(config conf.RedisConf)  {
+//Ultron 压测流量使用的 Redis，没有单独配置时就是 RedisClient
+var ShadowRedisClient *redis.Client
+
+//压测数据的 key 前缀
+var ShadowKeyPrefix string
+
+func InitRedis(config conf.RedisConf) error {
 	RedisClient = *redis.NewClient(&redis.Options{
 		Addr:config.Addr,
 		Password:"",
@@ -16,4 +23,31 @@ func InitRedis(config conf.RedisConf)  {
 	})
 	pong, err := RedisClient.Ping().Result()
 	fmt.Println(pong, "========", err)
+
+	shadow := config.Shadow
+	ShadowKeyPrefix = shadow.KeyPrefix
+	if config.ShadowSharesProd() {
+		//和线上共用同一个库，只能靠 key 前缀隔离压测数据，shadow.addr 显式写成线上地址时也一样
+		if shadow.KeyPrefix == "" {
+			return errors.New("shadow redis shares the production db, key_prefix must not be empty")
+		}
+		ShadowRedisClient = &RedisClient
+		return nil
+	}
+	ShadowRedisClient = redis.NewClient(&redis.Options{
+		Addr:config.ShadowAddr(),
+		Password:"",
+		DB:shadow.DB,
+	})
+	pong, err = ShadowRedisClient.Ping().Result()
+	fmt.Println(pong, "======== shadow", err)
+	return nil
+}
+
+//关闭 Redis 连接池，进程退出前调用
+func CloseRedis() error {
+	if ShadowRedisClient != nil && ShadowRedisClient != &RedisClient {
+		ShadowRedisClient.Close()
+	}
+	return RedisClient.Close()
 }
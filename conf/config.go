@@ -4,15 +4,203 @@ import (
 	"fmt"
 	"git.xiaojukeji.com/soda-framework/go-log"
 	"github.com/pelletier/go-toml"
-	"php-thrift-go-server/util"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 var (
 	GoServerConf Config
 )
 
+const (
+	DefaultAddr = "localhost:8999"
+	DefaultDrainTimeoutMS = 10000
+	DefaultShadowKeyPrefix = "_shadow_"
+	DefaultAdminAddr = "localhost:8997"
+	DefaultSlowLogFilePath = "./log/slow.log"
+	DefaultSlowLogThresholdMS = 100
+	DefaultAuthWindowSec = 300
+	DefaultAuditFilePath = "./log/audit.log"
+	DefaultOverloadShadowRatio = 0.5
+)
+
 type RedisConf struct {
 	Addr string 	`toml:"addr"`
+	Shadow	ShadowRedisConf	`toml:"shadow"`
+	Breaker	RedisBreakerConf	`toml:"breaker"`
+}
+
+//Redis 熔断器，连续失败 failure_threshold 次之后打开，open_timeout_ms 之后放行 half_open_probes 个探测请求，为 0 的参数使用默认值
+type RedisBreakerConf struct {
+	Enabled		bool		`toml:"enabled"`
+	FailureThreshold	int		`toml:"failure_threshold"`
+	OpenTimeoutMS	int		`toml:"open_timeout_ms"`
+	HalfOpenProbes	int		`toml:"half_open_probes"`
+}
+
+//Ultron 压测流量使用的影子 Redis，addr 为空时使用线上的 addr，和线上是同一个地址并且 db 为 0 时共用同一个库，只通过 key_prefix 区分
+type ShadowRedisConf struct {
+	Addr		string		`toml:"addr"`
+	DB		int		`toml:"db"`
+	KeyPrefix	string		`toml:"key_prefix"`	//压测数据的 key 前缀，和线上共用同一个库时不能为空
+}
+
+//影子 Redis 实际使用的地址，没有配置时和线上相同
+func (c RedisConf) ShadowAddr() string {
+	if c.Shadow.Addr == "" {
+		return c.Addr
+	}
+	return c.Shadow.Addr
+}
+
+//影子 Redis 是否和线上共用同一个库：地址相同并且 db 和线上一样是 0，localhost 和 127.0.0.1 等本机地址当作同一个地址
+func (c RedisConf) ShadowSharesProd() bool {
+	return c.Shadow.DB == 0 && sameAddr(c.ShadowAddr(), c.Addr)
+}
+
+func sameAddr(a, b string) bool {
+	if a == b {
+		return true
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	return hostA == hostB || (isLoopback(hostA) && isLoopback(hostB))
+}
+
+func isLoopback(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+type TLSConf struct {
+	Enabled		bool		`toml:"enabled"`
+	CertFile	string		`toml:"cert_file"`
+	KeyFile		string		`toml:"key_file"`
+	MinVersion	string		`toml:"min_version"`	//1.0、1.1、1.2、1.3
+	CipherSuites	[]string	`toml:"cipher_suites"`
+	ClientAuth	string		`toml:"client_auth"`	//none、request、require_verify
+	ClientCAFile	string		`toml:"client_ca_file"`
+	ReloadIntervalMS	int	`toml:"reload_interval_ms"`	//检查证书文件是否变化的间隔，0 表示只在收到 SIGHUP 时重新加载
+}
+
+//unix socket 监听，和 TCP 共用同一个 server
+type UnixConf struct {
+	Enabled		bool		`toml:"enabled"`
+	Path		string		`toml:"path"`
+	Mode		string		`toml:"mode"`	//八进制的文件权限，比如 "0660"
+	Owner		string		`toml:"owner"`	//用户名或者 uid，为空表示不修改
+	Group		string		`toml:"group"`	//组名或者 gid，为空表示不修改
+}
+
+//thrift server 的监听地址、协议、transport 和并发控制，并发参数为 0 表示使用 server 包的默认值
+type ServerConf struct {
+	Addr		string		`toml:"addr"`
+	Protocol	string		`toml:"protocol"`	//binary、compact、json、simplejson、auto
+	Transport	string		`toml:"transport"`	//plain、buffered、framed
+	BufferSize	int		`toml:"buffer_size"`
+	Zlib		bool		`toml:"zlib"`
+	ZlibLevel	int		`toml:"zlib_level"`
+	TLS		TLSConf		`toml:"tls"`
+	Unix		UnixConf	`toml:"unix"`
+	MaxConns	int		`toml:"max_conns"`
+	Workers		int		`toml:"workers"`
+	Backlog		int		`toml:"backlog"`
+	DrainTimeoutMS	int		`toml:"drain_timeout_ms"`	//退出时等待正在处理的请求结束的最长时间
+	//连接超时和解码大小限制，0 表示不限制
+	IdleTimeoutMS	int		`toml:"idle_timeout_ms"`	//等待下一个请求的最长时间
+	ReadTimeoutMS	int		`toml:"read_timeout_ms"`	//请求开始之后每次读数据的最长时间
+	WriteTimeoutMS	int		`toml:"write_timeout_ms"`
+	MaxFrameSize	int		`toml:"max_frame_size"`
+	MaxMessageSize	int		`toml:"max_message_size"`
+	MaxStringSize	int		`toml:"max_string_size"`
+	MaxContainerSize	int	`toml:"max_container_size"`
+}
+
+//thrift over HTTP 接口，和 thrift server 共用同一个 processor
+type HTTPConf struct {
+	Enabled		bool		`toml:"enabled"`
+	Addr		string		`toml:"addr"`
+	Path		string		`toml:"path"`
+	ReadTimeoutMS	int		`toml:"read_timeout_ms"`
+	WriteTimeoutMS	int		`toml:"write_timeout_ms"`
+	IdleTimeoutMS	int		`toml:"idle_timeout_ms"`
+	MaxBodyBytes	int64		`toml:"max_body_bytes"`
+}
+
+//运维接口，和 thrift 服务使用不同的端口，只应该对内网开放
+type AdminConf struct {
+	Enabled		bool		`toml:"enabled"`
+	Addr		string		`toml:"addr"`
+}
+
+//慢日志，耗时超过阈值的调用把各个阶段的耗时写到单独的文件
+type SlowLogConf struct {
+	Enabled		bool		`toml:"enabled"`
+	FilePath	string		`toml:"file_path"`
+	ThresholdMS	int		`toml:"threshold_ms"`	//0 表示记录所有调用
+	MethodThresholdMS	map[string]int	`toml:"method_threshold_ms"`	//按方法名覆盖 threshold_ms
+	Debug		bool		`toml:"debug"`	//在响应的 ResponseHeader.timing 中返回耗时分解，只应该在测试环境打开
+}
+
+//调用方认证，调用方用 app id 和 secret 对请求做 HMAC-SHA256 签名
+type AuthConf struct {
+	Enabled		bool		`toml:"enabled"`
+	Required	bool		`toml:"required"`	//为 false 时没有带签名的请求也可以调用，方便调用方逐步接入
+	WindowSec	int		`toml:"window_sec"`	//请求的时间戳和服务端时间相差超过这个值时当作重放拒绝，这个时间内重复的 nonce 也拒绝
+	Apps		map[string]AppConf	`toml:"apps"`	//key 是 app id
+}
+
+type AppConf struct {
+	Secret		string		`toml:"secret" secret:"true"`
+	MaxQPS		int		`toml:"max_qps"`	//每秒最多的签名请求数，用来分配 nonce 缓存，超过时返回限流，为 0 时是 1000
+}
+
+//方法级别的权限控制，调用方按来源 IP/CIDR、TLS 客户端证书身份或者 app id 匹配角色
+type AuthzConf struct {
+	Enabled		bool		`toml:"enabled"`
+	AuditFilePath	string		`toml:"audit_file_path"`	//拒绝的调用写到审计日志
+	Roles		map[string]RoleConf	`toml:"roles"`	//key 是角色名
+	Methods		map[string][]string	`toml:"methods"`	//service:method 到允许调用的角色，只写方法名时是默认 service 的方法，没有配置的方法不允许调用
+}
+
+//满足任意一条的调用方就有这个角色
+type RoleConf struct {
+	CIDRs		[]string	`toml:"cidrs"`
+	Identities	[]string	`toml:"identities"`	//TLS 客户端证书的 CommonName 或者第一个 DNS SAN
+	AppIDs		[]string	`toml:"app_ids"`	//签名认证过的 app id，需要开启 auth_conf
+}
+
+//按方法和调用方限流的令牌桶，收到 SIGHUP 时重新加载 rules，enabled 修改之后需要重启
+type RateLimitConf struct {
+	Enabled		bool		`toml:"enabled"`
+	Rules		[]RateLimitRule	`toml:"rules"`	//一个请求匹配多条规则时每条规则都要有令牌
+}
+
+type RateLimitRule struct {
+	Method		string		`toml:"method"`	//方法名，"*" 匹配所有方法
+	Caller		string		`toml:"caller"`	//app id、TLS 客户端证书身份或者客户端 IP，"*" 匹配所有调用方
+	Rate		float64		`toml:"rate"`	//每秒补充的令牌数
+	Burst		int		`toml:"burst"`	//桶的容量，0 表示 rate 向上取整
+	Shared		bool		`toml:"shared"`	//匹配的请求共用一个桶，默认每个方法和调用方的组合一个桶
+}
+
+//自适应的并发上限，按请求耗时调整，超过上限的请求直接返回 overload，为 0 的参数使用默认值
+type OverloadConf struct {
+	Enabled		bool		`toml:"enabled"`
+	InitialLimit	int		`toml:"initial_limit"`
+	MinLimit	int		`toml:"min_limit"`
+	MaxLimit	int		`toml:"max_limit"`
+	LatencyTargetMS	int		`toml:"latency_target_ms"`	//耗时超过这个值时降低上限
+	Backoff		float64		`toml:"backoff"`	//降低上限时乘以这个值
+	ShadowRatio	float64		`toml:"shadow_ratio"`	//Ultron 压测流量最多使用上限的这个比例，0 表示拒绝所有压测流量，没有配置时是 0.5
 }
 
 //type LogConf struct {
@@ -24,29 +212,266 @@ type RedisConf struct {
 
 
 type Config struct {
+	ServerConf	ServerConf		`toml:"server_conf"`
+	HTTPConf	HTTPConf		`toml:"http_conf"`
+	AdminConf	AdminConf		`toml:"admin_conf"`
+	SlowLogConf	SlowLogConf		`toml:"slow_log_conf"`
+	AuthConf	AuthConf		`toml:"auth_conf"`
+	AuthzConf	AuthzConf		`toml:"authz_conf"`
+	RateLimitConf	RateLimitConf		`toml:"ratelimit_conf"`
+	OverloadConf	OverloadConf		`toml:"overload_conf"`
 	RedisConf 	RedisConf		`toml:"redis_conf"`
 	LogConf 	log.Config		`toml:"log_conf"`
 }
 
-func LoadConfigFile(path string) (err error) {
-	//confFilePath := "conf/service.conf"
-	//absConfPath, err := filepath.Abs(confFilePath)
-	//fmt.Println("load config from ", absConfPath)
-
-
-	tomlTree, err := toml.LoadFile(path)
-	GoServerConf = Config{
+//所有配置项的默认值，配置文件中没有写的配置项使用这里的值，为 0 的并发、超时和大小限制表示使用各个包自己的默认值或者不限制
+func DefaultConfig() Config {
+	return Config{
+		ServerConf:ServerConf{
+			Addr:DefaultAddr,
+			Protocol:"binary",
+			Transport:"plain",
+			ZlibLevel:-1,	//zlib 的默认压缩级别
+			TLS:TLSConf{
+				CertFile:"server.crt",
+				KeyFile:"server.key",
+				MinVersion:"1.2",
+				ClientAuth:"none",
+			},
+			Unix:UnixConf{
+				Mode:"0660",
+			},
+			DrainTimeoutMS:DefaultDrainTimeoutMS,
+		},
+		HTTPConf:HTTPConf{
+			Addr:"localhost:8998",
+			Path:"/",
+		},
+		AdminConf:AdminConf{
+			Addr:DefaultAdminAddr,
+		},
+		SlowLogConf:SlowLogConf{
+			FilePath:DefaultSlowLogFilePath,
+			ThresholdMS:DefaultSlowLogThresholdMS,
+		},
+		AuthConf:AuthConf{
+			WindowSec:DefaultAuthWindowSec,
+		},
+		AuthzConf:AuthzConf{
+			AuditFilePath:DefaultAuditFilePath,
+		},
+		OverloadConf:OverloadConf{
+			ShadowRatio:DefaultOverloadShadowRatio,
+		},
 		RedisConf:RedisConf{
-			Addr:tomlTree.Get("redis_conf.addr").(string),
+			Shadow:ShadowRedisConf{
+				KeyPrefix:DefaultShadowKeyPrefix,
+			},
 		},
 		LogConf:log.Config{
-			FilePath:tomlTree.Get("log_conf.file_path").(string),
-			ErrorFilePath:tomlTree.Get("log_conf.error_file_path").(string),
-			Level:tomlTree.Get("log_conf.level").(string),
-			MaxSizeMB:int(tomlTree.Get("log_conf.max_size_mb").(int64)),
+			FilePath:log.DefaultFilePath,
+			ErrorFilePath:log.DefaultErrorFilePath,
+			Level:log.DefaultLevel,
+			Formatter:log.DefaultFormatter,
 		},
 	}
-	fmt.Println(util.JsonString(GoServerConf))
-	//todo 此处我要打印这个config结果失败了，json解析不出来，不知道为啥
-	return err
-}
\ No newline at end of file
+}
+
+//rule 中没有写 caller 时匹配所有调用方
+func (rule *RateLimitRule) setDefaults() {
+	rule.Caller = "*"
+}
+
+//读取配置文件到 GoServerConf，文件不存在、格式错误或者配置项不合法时返回错误，GoServerConf 不变。
+//配置项不合法时返回 Errors，包含所有错误的配置项
+func LoadConfigFile(path string) error {
+	config, err := load(path)
+	if err != nil {
+		return err
+	}
+	GoServerConf = config
+	return nil
+}
+
+//只重新读取配置文件中的限流配置，用于运行时更新限流规则，文件中任何配置项不合法时都返回错误
+func LoadRateLimitConf(path string) (RateLimitConf, error) {
+	config, err := load(path)
+	if err != nil {
+		return RateLimitConf{}, err
+	}
+	return config.RateLimitConf, nil
+}
+
+func load(path string) (Config, error) {
+	tomlTree, err := toml.LoadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("load %v: %v", path, err)
+	}
+	return decodeConfig(tomlTree)
+}
+
+//在默认值上解码配置，然后校验
+func decodeConfig(tomlTree *toml.TomlTree) (Config, error) {
+	config := DefaultConfig()
+	d := newDecoder()
+	d.decodeStruct(tomlTree, reflect.ValueOf(&config).Elem(), "")
+	config.validate(d.errorf)
+	if len(d.errs) > 0 {
+		d.errs.sort()
+		return Config{}, d.errs
+	}
+	return config, nil
+}
+
+//校验配置项的取值，没有开启的模块不校验
+func (c *Config) validate(errorf func(path string, format string, args ...interface{})) {
+	nonNegative := func(path string, v int) {
+		if v < 0 {
+			errorf(path, "must not be negative, got %v", v)
+		}
+	}
+	notEmpty := func(path string, v string) {
+		if v == "" {
+			errorf(path, "must not be empty")
+		}
+	}
+	oneOf := func(path string, v string, values ...string) {
+		for _, value := range values {
+			if v == value {
+				return
+			}
+		}
+		errorf(path, "must be one of %v, got %q", strings.Join(values, ", "), v)
+	}
+
+	server := c.ServerConf
+	notEmpty("server_conf.addr", server.Addr)
+	oneOf("server_conf.protocol", server.Protocol, "binary", "compact", "json", "simplejson", "auto")
+	oneOf("server_conf.transport", server.Transport, "plain", "buffered", "framed")
+	if server.ZlibLevel < -1 || server.ZlibLevel > 9 {
+		errorf("server_conf.zlib_level", "must be between -1 and 9, got %v", server.ZlibLevel)
+	}
+	nonNegative("server_conf.buffer_size", server.BufferSize)
+	nonNegative("server_conf.max_conns", server.MaxConns)
+	nonNegative("server_conf.workers", server.Workers)
+	nonNegative("server_conf.backlog", server.Backlog)
+	nonNegative("server_conf.drain_timeout_ms", server.DrainTimeoutMS)
+	nonNegative("server_conf.idle_timeout_ms", server.IdleTimeoutMS)
+	nonNegative("server_conf.read_timeout_ms", server.ReadTimeoutMS)
+	nonNegative("server_conf.write_timeout_ms", server.WriteTimeoutMS)
+	nonNegative("server_conf.max_frame_size", server.MaxFrameSize)
+	nonNegative("server_conf.max_message_size", server.MaxMessageSize)
+	nonNegative("server_conf.max_string_size", server.MaxStringSize)
+	nonNegative("server_conf.max_container_size", server.MaxContainerSize)
+	if tlsConf := server.TLS; tlsConf.Enabled {
+		notEmpty("server_conf.tls.cert_file", tlsConf.CertFile)
+		notEmpty("server_conf.tls.key_file", tlsConf.KeyFile)
+		oneOf("server_conf.tls.min_version", tlsConf.MinVersion, "1.0", "1.1", "1.2", "1.3")
+		oneOf("server_conf.tls.client_auth", tlsConf.ClientAuth, "none", "request", "require_verify")
+		if tlsConf.ClientAuth != "none" && tlsConf.ClientCAFile == "" {
+			errorf("server_conf.tls.client_ca_file", "must not be empty when client_auth is %q", tlsConf.ClientAuth)
+		}
+		nonNegative("server_conf.tls.reload_interval_ms", tlsConf.ReloadIntervalMS)
+	}
+	if unix := server.Unix; unix.Enabled {
+		notEmpty("server_conf.unix.path", unix.Path)
+		if _, err := strconv.ParseUint(unix.Mode, 8, 32); err != nil {
+			errorf("server_conf.unix.mode", "must be an octal file mode like \"0660\", got %q", unix.Mode)
+		}
+	}
+
+	if httpConf := c.HTTPConf; httpConf.Enabled {
+		notEmpty("http_conf.addr", httpConf.Addr)
+		if !strings.HasPrefix(httpConf.Path, "/") {
+			errorf("http_conf.path", "must start with /, got %q", httpConf.Path)
+		}
+		nonNegative("http_conf.read_timeout_ms", httpConf.ReadTimeoutMS)
+		nonNegative("http_conf.write_timeout_ms", httpConf.WriteTimeoutMS)
+		nonNegative("http_conf.idle_timeout_ms", httpConf.IdleTimeoutMS)
+		if httpConf.MaxBodyBytes < 0 {
+			errorf("http_conf.max_body_bytes", "must not be negative, got %v", httpConf.MaxBodyBytes)
+		}
+	}
+
+	if c.AdminConf.Enabled {
+		notEmpty("admin_conf.addr", c.AdminConf.Addr)
+	}
+
+	if slowLog := c.SlowLogConf; slowLog.Enabled {
+		notEmpty("slow_log_conf.file_path", slowLog.FilePath)
+		nonNegative("slow_log_conf.threshold_ms", slowLog.ThresholdMS)
+		for method, threshold := range slowLog.MethodThresholdMS {
+			nonNegative("slow_log_conf.method_threshold_ms."+method, threshold)
+		}
+	}
+
+	if auth := c.AuthConf; auth.Enabled {
+		if auth.WindowSec <= 0 {
+			errorf("auth_conf.window_sec", "must be positive, got %v", auth.WindowSec)
+		}
+		for appID, app := range auth.Apps {
+			notEmpty("auth_conf.apps."+appID+".secret", app.Secret)
+			nonNegative("auth_conf.apps."+appID+".max_qps", app.MaxQPS)
+		}
+	}
+
+	if authz := c.AuthzConf; authz.Enabled {
+		notEmpty("authz_conf.audit_file_path", authz.AuditFilePath)
+		for method, roles := range authz.Methods {
+			for i, role := range roles {
+				if _, ok := authz.Roles[role]; !ok {
+					errorf(fmt.Sprintf("authz_conf.methods.%v[%v]", method, i), "undefined role %q", role)
+				}
+			}
+		}
+	}
+
+	if c.RateLimitConf.Enabled {
+		for i, rule := range c.RateLimitConf.Rules {
+			path := fmt.Sprintf("ratelimit_conf.rules[%v]", i)
+			notEmpty(path+".method", rule.Method)
+			notEmpty(path+".caller", rule.Caller)
+			if rule.Rate <= 0 {
+				errorf(path+".rate", "must be positive, got %v", rule.Rate)
+			}
+			nonNegative(path+".burst", rule.Burst)
+		}
+	}
+
+	if overload := c.OverloadConf; overload.Enabled {
+		nonNegative("overload_conf.initial_limit", overload.InitialLimit)
+		nonNegative("overload_conf.min_limit", overload.MinLimit)
+		nonNegative("overload_conf.max_limit", overload.MaxLimit)
+		if overload.MinLimit > 0 && overload.MaxLimit > 0 && overload.MinLimit > overload.MaxLimit {
+			errorf("overload_conf.min_limit", "must not be greater than max_limit %v, got %v", overload.MaxLimit, overload.MinLimit)
+		}
+		nonNegative("overload_conf.latency_target_ms", overload.LatencyTargetMS)
+		if overload.Backoff < 0 || overload.Backoff >= 1 {
+			errorf("overload_conf.backoff", "must be in [0, 1), got %v", overload.Backoff)
+		}
+		if overload.ShadowRatio < 0 || overload.ShadowRatio > 1 {
+			errorf("overload_conf.shadow_ratio", "must be in [0, 1], got %v", overload.ShadowRatio)
+		}
+	}
+
+	redisConf := c.RedisConf
+	notEmpty("redis_conf.addr", redisConf.Addr)
+	nonNegative("redis_conf.shadow.db", redisConf.Shadow.DB)
+	if redisConf.ShadowSharesProd() && redisConf.Shadow.KeyPrefix == "" {
+		errorf("redis_conf.shadow.key_prefix", "must not be empty when shadow redis %v db %v is the production db", redisConf.ShadowAddr(), redisConf.Shadow.DB)
+	}
+	if breaker := redisConf.Breaker; breaker.Enabled {
+		nonNegative("redis_conf.breaker.failure_threshold", breaker.FailureThreshold)
+		nonNegative("redis_conf.breaker.open_timeout_ms", breaker.OpenTimeoutMS)
+		nonNegative("redis_conf.breaker.half_open_probes", breaker.HalfOpenProbes)
+	}
+
+	logConf := c.LogConf
+	notEmpty("log_conf.file_path", logConf.FilePath)
+	if _, ok := log.ParseLevel(logConf.Level); !ok {
+		errorf("log_conf.level", "must be one of debug, info, warn, error, fatal, panic, got %q", logConf.Level)
+	}
+	oneOf("log_conf.formatter", strings.ToLower(logConf.Formatter), "text", "json")
+	nonNegative("log_conf.max_size_mb", logConf.MaxSizeMB)
+	nonNegative("log_conf.max_backups", logConf.MaxBackups)
+}
@@ -1,8 +1,167 @@
 package conf
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+)
 
 //此处涉及到conf文件目录位置问题，main方法调用和test方法调用路径是不一致的，首先 保全main包
 func TestLoadConfigFile(t *testing.T) {
-	LoadConfigFile()
+	if err := LoadConfigFile("service.conf"); err != nil {
+		t.Fatalf("fail to load service.conf. [err:%v]", err)
+	}
+
+	if err := LoadConfigFile("not_exist.conf"); err == nil {
+		t.Fatalf("missing file must be reported.")
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	tree, err := toml.Load(`
+[server_conf]
+protocol = "compact"
+
+[redis_conf]
+addr = "127.0.0.1:6379"
+
+[ratelimit_conf]
+enabled = true
+
+[[ratelimit_conf.rules]]
+method = "SetUsers"
+rate = 10
+
+[log_conf]
+max_backups = 3
+formatter = "json"
+`)
+
+	if err != nil {
+		t.Fatalf("fail to parse toml. [err:%v]", err)
+	}
+
+	config, err := decodeConfig(tree)
+
+	if err != nil {
+		t.Fatalf("fail to decode config. [err:%v]", err)
+	}
+
+	// 没有写的配置项使用默认值。
+	if config.ServerConf.Protocol != "compact" || config.ServerConf.Addr != DefaultAddr || config.ServerConf.DrainTimeoutMS != DefaultDrainTimeoutMS {
+		t.Fatalf("invalid server conf. [actual:%+v]", config.ServerConf)
+	}
+
+	if rules := config.RateLimitConf.Rules; len(rules) != 1 || rules[0].Caller != "*" || rules[0].Rate != 10 {
+		t.Fatalf("invalid rules. [actual:%+v]", rules)
+	}
+
+	if logConf := config.LogConf; logConf.MaxBackups != 3 || logConf.Formatter != "json" || logConf.FilePath != "./log/all.log" {
+		t.Fatalf("invalid log conf. [actual:%+v]", logConf)
+	}
+
+	if config.OverloadConf.ShadowRatio != DefaultOverloadShadowRatio {
+		t.Fatalf("unset shadow ratio must use default. [actual:%v]", config.OverloadConf.ShadowRatio)
+	}
+
+	// 明确配置为 0 时不能被改成默认值。
+	tree, _ = toml.Load("[overload_conf]\nshadow_ratio = 0.0\n[redis_conf]\naddr = \"127.0.0.1:6379\"\n")
+
+	if config, err = decodeConfig(tree); err != nil || config.OverloadConf.ShadowRatio != 0 {
+		t.Fatalf("explicit zero shadow ratio must be kept. [err:%v] [actual:%v]", err, config.OverloadConf.ShadowRatio)
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	tree, err := toml.Load(`
+[server_conf]
+protocol = "thrift"
+max_conns = "many"
+listen = "localhost:8999"
+
+[ratelimit_conf]
+enabled = true
+
+[[ratelimit_conf.rules]]
+method = "SetUsers"
+rate = 0
+
+[log_conf]
+level = "verbose"
+`)
+
+	if err != nil {
+		t.Fatalf("fail to parse toml. [err:%v]", err)
+	}
+
+	_, err = decodeConfig(tree)
+	errs, ok := err.(Errors)
+
+	if !ok {
+		t.Fatalf("errors must be reported as Errors. [err:%v]", err)
+	}
+
+	expected := []string{
+		`server_conf.protocol (line 3): must be one of binary, compact, json, simplejson, auto, got "thrift"`,
+		`server_conf.max_conns (line 4): expected integer, got string`,
+		`server_conf.listen (line 5): unknown key`,
+		`ratelimit_conf.rules[0].rate (line 12): must be positive, got 0`,
+		`log_conf.level (line 15): must be one of debug, info, warn, error, fatal, panic, got "verbose"`,
+		`redis_conf.addr: must not be empty`,
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("all invalid fields must be reported. [actual:%v]", err)
+	}
+
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Fatalf("invalid error. [index:%v] [expected:%v] [actual:%v]", i, expected[i], e)
+		}
+	}
+
+	if msg := err.Error(); !strings.HasPrefix(msg, "6 invalid config field(s):\n  server_conf.protocol") {
+		t.Fatalf("invalid message. [actual:%v]", msg)
+	}
+}
+
+func TestShadowSharesProd(t *testing.T) {
+	cases := []struct {
+		shadow ShadowRedisConf
+		shared bool
+	}{
+		{ShadowRedisConf{}, true},
+		{ShadowRedisConf{Addr: "127.0.0.1:6379"}, true},
+		{ShadowRedisConf{Addr: "localhost:6379"}, true},
+		{ShadowRedisConf{Addr: "127.0.0.1:6379", DB: 1}, false},
+		{ShadowRedisConf{Addr: "127.0.0.1:6380"}, false},
+		{ShadowRedisConf{Addr: "10.0.0.1:6379"}, false},
+	}
+
+	for i, c := range cases {
+		redisConf := RedisConf{Addr: "127.0.0.1:6379", Shadow: c.shadow}
+
+		if shared := redisConf.ShadowSharesProd(); shared != c.shared {
+			t.Fatalf("invalid result. [case:%v] [shared:%v]", i, shared)
+		}
+	}
+
+	// shadow.addr 显式写成线上地址时 key_prefix 也不能为空。
+	tree, err := toml.Load(`
+[redis_conf]
+addr = "127.0.0.1:6379"
+
+[redis_conf.shadow]
+addr = "127.0.0.1:6379"
+key_prefix = ""
+`)
+
+	if err != nil {
+		t.Fatalf("fail to parse toml. [err:%v]", err)
+	}
+
+	if _, err := decodeConfig(tree); err == nil || !strings.Contains(err.Error(), "redis_conf.shadow.key_prefix (line 7)") {
+		t.Fatalf("empty prefix on production db must be rejected. [err:%v]", err)
+	}
 }
@@ -0,0 +1,248 @@
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+//FieldError 是一个配置项的错误，Path 是配置项的完整路径，比如 server_conf.tls.min_version、ratelimit_conf.rules[1].rate
+type FieldError struct {
+	Path string
+	Line int //配置文件中的行号，配置项没有写在文件中时为 0
+	Msg  string
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%v (line %v): %v", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%v: %v", e.Path, e.Msg)
+}
+
+//Errors 是配置文件中所有的错误，按行号排序，没有写在文件中的配置项排在最后
+type Errors []*FieldError
+
+func (errs Errors) sort() {
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+		if (a.Line == 0) != (b.Line == 0) {
+			return b.Line == 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Path < b.Path
+	})
+}
+
+func (errs Errors) Error() string {
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("%v invalid config field(s):", len(errs)))
+	for _, e := range errs {
+		lines = append(lines, "  "+e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+//数组和 map 中的元素在解码之前调用 setDefaults 设置默认值
+type defaulter interface {
+	setDefaults()
+}
+
+//把 toml 解码到带 toml tag 的结构体，文件中没有的配置项保留原来的值。
+//类型不匹配和结构体中没有的 key 都记录为错误，不会中途停止，这样一次可以报告所有错误。
+type decoder struct {
+	positions map[string]toml.Position //配置项在文件中的位置，校验时用来报告行号
+	errs      Errors
+}
+
+func newDecoder() *decoder {
+	return &decoder{positions: make(map[string]toml.Position)}
+}
+
+func (d *decoder) errorf(path string, format string, args ...interface{}) {
+	d.errs = append(d.errs, &FieldError{Path: path, Line: d.positions[path].Line, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (d *decoder) decodeStruct(tree *toml.TomlTree, v reflect.Value, path string) {
+	fields := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		if tag := v.Type().Field(i).Tag.Get("toml"); tag != "" && tag != "-" {
+			fields[tag] = i
+		}
+	}
+	keys := tree.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		fieldPath := joinPath(path, key)
+		d.positions[fieldPath] = tree.GetPosition(key)
+		i, ok := fields[key]
+		if !ok {
+			d.errorf(fieldPath, "unknown key")
+			continue
+		}
+		d.decode(tree.Get(key), v.Field(i), fieldPath)
+	}
+}
+
+func (d *decoder) decode(value interface{}, v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		tree, ok := value.(*toml.TomlTree)
+		if !ok {
+			d.typeError(value, v, path)
+			return
+		}
+		d.decodeStruct(tree, v, path)
+	case reflect.Map:
+		tree, ok := value.(*toml.TomlTree)
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			d.typeError(value, v, path)
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		keys := tree.Keys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			elemPath := joinPath(path, key)
+			d.positions[elemPath] = tree.GetPosition(key)
+			elem := d.newElem(v.Type().Elem())
+			d.decode(tree.Get(key), elem, elemPath)
+			v.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+	case reflect.Slice:
+		var values []interface{}
+		switch value := value.(type) {
+		case []interface{}:
+			values = value
+		case []*toml.TomlTree:
+			for _, tree := range value {
+				values = append(values, tree)
+			}
+		default:
+			d.typeError(value, v, path)
+			return
+		}
+		slice := reflect.MakeSlice(v.Type(), 0, len(values))
+		for i, value := range values {
+			elemPath := fmt.Sprintf("%v[%v]", path, i)
+			if tree, ok := value.(*toml.TomlTree); ok {
+				d.positions[elemPath] = tree.GetPosition("")
+			} else {
+				d.positions[elemPath] = d.positions[path]
+			}
+			elem := d.newElem(v.Type().Elem())
+			d.decode(value, elem, elemPath)
+			slice = reflect.Append(slice, elem)
+		}
+		v.Set(slice)
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			d.typeError(value, v, path)
+			return
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			d.typeError(value, v, path)
+			return
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok {
+			d.typeError(value, v, path)
+			return
+		}
+		if v.OverflowInt(n) {
+			d.errorf(path, "%v is out of range", n)
+			return
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		//浮点数写成整数也可以
+		switch n := value.(type) {
+		case float64:
+			v.SetFloat(n)
+		case int64:
+			v.SetFloat(float64(n))
+		default:
+			d.typeError(value, v, path)
+		}
+	default:
+		d.errorf(path, "unsupported field type %v", v.Type())
+	}
+}
+
+//创建数组或者 map 的元素，元素实现了 defaulter 时先设置默认值
+func (d *decoder) newElem(typ reflect.Type) reflect.Value {
+	elem := reflect.New(typ)
+	if def, ok := elem.Interface().(defaulter); ok {
+		def.setDefaults()
+	}
+	return elem.Elem()
+}
+
+func (d *decoder) typeError(value interface{}, v reflect.Value, path string) {
+	d.errorf(path, "expected %v, got %v", kindName(v.Type()), valueName(value))
+}
+
+//配置项类型在错误信息中的名字，和 TOML 的类型名一致
+func kindName(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "table"
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Struct {
+			return "array of tables"
+		}
+		return "array"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	}
+	return typ.String()
+}
+
+func valueName(value interface{}) string {
+	switch value.(type) {
+	case *toml.TomlTree:
+		return "table"
+	case []*toml.TomlTree:
+		return "array of tables"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "float"
+	case int64:
+		return "integer"
+	case time.Time:
+		return "datetime"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+// 生成的代码要和 php-go.thrift 一致，修改 IDL 之后要用 thrift-gen.sh 重新生成 gen-go 和 gen-php。
+const idlDir = "vendor/github.com/yingongzi/php-thrift/"
+
+var idlStructs = map[string]reflect.Type{
+	"UserInfo":        reflect.TypeOf(idl.UserInfo{}),
+	"ResponseHeader":  reflect.TypeOf(idl.ResponseHeader{}),
+	"Auth":            reflect.TypeOf(idl.Auth{}),
+	"GetUserByIdReq":  reflect.TypeOf(idl.GetUserByIdReq{}),
+	"GetUserByIdResp": reflect.TypeOf(idl.GetUserByIdResp{}),
+	"SetUsersReq":     reflect.TypeOf(idl.SetUsersReq{}),
+	"SetUsersResp":    reflect.TypeOf(idl.SetUsersResp{}),
+}
+
+type idlField struct {
+	id       string
+	name     string
+	typ      string
+	optional bool
+	required bool
+}
+
+var (
+	idlStructRe  = regexp.MustCompile(`(?s)struct\s+(\w+)\s*\{(.*?)\}`)
+	idlFieldRe   = regexp.MustCompile(`(\d+)\s*:\s*(required|optional)?\s*([\w.]+(?:<[^>]*>)?)\s+(\w+)`)
+	idlCommentRe = regexp.MustCompile(`//[^\n]*`)
+	phpClassRe   = regexp.MustCompile(`(?m)^class (\w+) `)
+	phpFieldRe   = regexp.MustCompile(`(\d+) => array\(\s*'var' => '(\w+)',\s*'type' => TType::(\w+)`)
+)
+
+func parseIDL(t *testing.T) map[string][]idlField {
+	data, err := ioutil.ReadFile(idlDir + "php-go.thrift")
+
+	if err != nil {
+		t.Fatalf("fail to read idl. [err:%v]", err)
+	}
+
+	structs := make(map[string][]idlField)
+
+	for _, m := range idlStructRe.FindAllStringSubmatch(idlCommentRe.ReplaceAllString(string(data), ""), -1) {
+		for _, f := range idlFieldRe.FindAllStringSubmatch(m[2], -1) {
+			structs[m[1]] = append(structs[m[1]], idlField{
+				id:       f[1],
+				name:     f[4],
+				typ:      strings.Replace(f[3], " ", "", -1),
+				optional: f[2] == "optional",
+				required: f[2] == "required",
+			})
+		}
+	}
+
+	return structs
+}
+
+// Go 生成代码中的类型，optional 的基本类型是指针，struct 总是指针。
+func goType(f idlField) string {
+	switch f.typ {
+	case "i32", "i64", "string", "bool":
+		typ := map[string]string{"i32": "int32", "i64": "int64", "string": "string", "bool": "bool"}[f.typ]
+
+		if f.optional {
+			return "*" + typ
+		}
+
+		return typ
+	case "map<string,string>":
+		return "map[string]string"
+	case "list<i32>":
+		return "[]int32"
+	}
+
+	return "*idl." + f.typ
+}
+
+func phpType(f idlField) string {
+	switch f.typ {
+	case "i32":
+		return "I32"
+	case "i64":
+		return "I64"
+	case "string":
+		return "STRING"
+	case "bool":
+		return "BOOL"
+	}
+
+	if strings.HasPrefix(f.typ, "map<") {
+		return "MAP"
+	}
+
+	if strings.HasPrefix(f.typ, "list<") {
+		return "LST"
+	}
+
+	return "STRUCT"
+}
+
+func TestGeneratedGoMatchesIDL(t *testing.T) {
+	structs := parseIDL(t)
+
+	if len(structs) != len(idlStructs) {
+		t.Fatalf("every idl struct must be checked. [idl:%v] [checked:%v]", len(structs), len(idlStructs))
+	}
+
+	for name, fields := range structs {
+		typ, ok := idlStructs[name]
+
+		if !ok {
+			t.Fatalf("struct is not generated. [struct:%v]", name)
+		}
+
+		generated := make(map[string]reflect.StructField)
+
+		for i := 0; i < typ.NumField(); i++ {
+			if tag := typ.Field(i).Tag.Get("thrift"); tag != "" {
+				generated[tag] = typ.Field(i)
+			}
+		}
+
+		if len(generated) != len(fields) {
+			t.Fatalf("field count mismatch. [struct:%v] [idl:%v] [go:%v]", name, len(fields), len(generated))
+		}
+
+		for _, f := range fields {
+			tag := f.name + "," + f.id
+
+			if f.required {
+				tag += ",required"
+			}
+
+			field, ok := generated[tag]
+
+			if !ok {
+				t.Fatalf("field is not generated. [struct:%v] [tag:%v]", name, tag)
+			}
+
+			if field.Type.String() != goType(f) {
+				t.Fatalf("field type mismatch. [struct:%v] [field:%v] [idl:%v] [go:%v]", name, f.name, goType(f), field.Type)
+			}
+		}
+	}
+}
+
+func TestGeneratedPHPMatchesIDL(t *testing.T) {
+	data, err := ioutil.ReadFile(idlDir + "gen-php/php_go/idl/Types.php")
+
+	if err != nil {
+		t.Fatalf("fail to read generated php. [err:%v]", err)
+	}
+
+	src := string(data)
+	classes := make(map[string]string)
+	locs := phpClassRe.FindAllStringSubmatchIndex(src, -1)
+
+	for i, loc := range locs {
+		end := len(src)
+
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+
+		classes[src[loc[2]:loc[3]]] = src[loc[0]:end]
+	}
+
+	for name, fields := range parseIDL(t) {
+		class, ok := classes[name]
+
+		if !ok {
+			t.Fatalf("class is not generated. [class:%v]", name)
+		}
+
+		generated := make(map[string][2]string)
+
+		for _, m := range phpFieldRe.FindAllStringSubmatch(class, -1) {
+			generated[m[1]] = [2]string{m[2], m[3]}
+		}
+
+		if len(generated) != len(fields) {
+			t.Fatalf("field count mismatch. [class:%v] [idl:%v] [php:%v]", name, len(fields), len(generated))
+		}
+
+		for _, f := range fields {
+			if expected := [2]string{f.name, phpType(f)}; generated[f.id] != expected {
+				t.Fatalf("field mismatch. [class:%v] [id:%v] [idl:%v] [php:%v]", name, f.id, expected, generated[f.id])
+			}
+		}
+	}
+}
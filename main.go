@@ -1,63 +1,537 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"git.apache.org/thrift.git/lib/go/thrift"
 	"git.xiaojukeji.com/soda-framework/go-log"
 	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"php-thrift-go-server/admin"
+	"php-thrift-go-server/auth"
+	"php-thrift-go-server/authz"
 	"php-thrift-go-server/client"
 	"php-thrift-go-server/conf"
+	"php-thrift-go-server/metrics"
+	"php-thrift-go-server/middleware"
+	"php-thrift-go-server/overload"
+	"php-thrift-go-server/ratelimit"
+	"php-thrift-go-server/rpc"
+	"php-thrift-go-server/server"
 	"php-thrift-go-server/service"
+	"php-thrift-go-server/util"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-const (
-	ADDR = "localhost:8999"
-
-)
+const configFile = "conf/service.conf"
 
 func main()  {
 	//加载配置文件
-	conf.LoadConfigFile("conf/service.conf")
+	if err := conf.LoadConfigFile(configFile); err != nil {
+		fmt.Fprintln(os.Stderr, "error loading config:", err)
+		os.Exit(1)
+	}
 	config := conf.GoServerConf
+	//密码和 secret 不能打印出来，和 admin 的 /config 一样替换成 ******
+	fmt.Println(util.JsonString(admin.Redact(config)))
 	//log 模块的初始化
 	log.Init(&config.LogConf)
 	defer log.Close()
 	//Redis模块的初始化
-	client.InitRedis(config.RedisConf)
+	if err := client.InitRedis(config.RedisConf); err != nil {
+		fmt.Println("error initializing redis:", err)
+		return
+	}
+	defer client.CloseRedis()
+	if breakerConf := config.RedisConf.Breaker; breakerConf.Enabled {
+		rpc.EnableBreaker(rpc.BreakerOptions{
+			FailureThreshold: breakerConf.FailureThreshold,
+			OpenTimeout:      time.Duration(breakerConf.OpenTimeoutMS) * time.Millisecond,
+			HalfOpenProbes:   breakerConf.HalfOpenProbes,
+		})
+	}
+
+	//TLS 证书，文件变化或者收到 SIGHUP 时重新加载
+	var tlsReloader *server.TLSReloader
+	quit := make(chan struct{})
+	defer close(quit)
+	if config.ServerConf.TLS.Enabled {
+		var err error
+		if tlsReloader, err = newTLSReloader(config.ServerConf.TLS); err != nil {
+			fmt.Println("error loading tls config:", err)
+			return
+		}
+		go tlsReloader.Watch(quit)
+	}
+
+	//监听 socket 优先从 systemd socket activation 或者热重启的父进程继承
+	listeners, err := server.NewListenerSet()
+	if err != nil {
+		fmt.Println("error inheriting listeners:", err)
+		return
+	}
+
+	// thrift 服务启动，每个连接创建一个 processor，handler 可以从 ctx 拿到连接信息
+	// 所有服务共用一个端口，没有带服务名的请求交给 Php_Go_Svr 处理
+	limiter, err := newRateLimiter(config.RateLimitConf)
+	if err != nil {
+		fmt.Println("error creating rate limiter:", err)
+		return
+	}
+	var overloadLimiter *overload.Limiter
+	if config.OverloadConf.Enabled {
+		overloadLimiter = newOverloadLimiter(config.OverloadConf)
+	}
+	registry, err := newRegistry(config, limiter, overloadLimiter)
+	if err != nil {
+		fmt.Println("error registering services:", err)
+		return
+	}
+	processorFactory := registry.ProcessorFactory()
+	svr, err := newServer(config.ServerConf, processorFactory, tlsReloader, listeners)
+	if err != nil {
+		fmt.Println("error creating server:", err)
+		return
+	}
+
+	//thrift over HTTP 服务启动
+	var httpSvr *http.Server
+	httpDone := make(chan struct{})
+	if config.HTTPConf.Enabled {
+		ln, err := listeners.Listen("http", "tcp", config.HTTPConf.Addr, nil)
+		if err != nil {
+			fmt.Println("error listening http:", err)
+			return
+		}
+		httpSvr = newHTTPServer(config.HTTPConf, processorFactory)
+		go func() {
+			defer close(httpDone)
+			fmt.Println("Starting the http server... on ", config.HTTPConf.Addr)
+			if err := httpSvr.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Errorf("main||error running http server||err=%v", err)
+			}
+		}()
+	} else {
+		close(httpDone)
+	}
+
+	//运维接口，Redis 不通或者 server 停止接受连接时 /ready 返回 503
+	if config.AdminConf.Enabled {
+		registerMetrics(svr, overloadLimiter)
+		ln, err := listeners.Listen("admin", "tcp", config.AdminConf.Addr, nil)
+		if err != nil {
+			fmt.Println("error listening admin:", err)
+			return
+		}
+		adminSvr := newAdminServer(config, svr, limiter, overloadLimiter)
+		defer adminSvr.Close()
+		go func() {
+			fmt.Println("Starting the admin server... on ", config.AdminConf.Addr)
+			if err := adminSvr.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Errorf("main||error running admin server||err=%v", err)
+			}
+		}()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		fmt.Println("Starting the server... on ", config.ServerConf.Addr)
+		serveErr <- svr.Serve()
+	}()
+	listeners.CloseUnused()
+	if err := listeners.NotifyParent(); err != nil {
+		log.Errorf("main||fail to notify parent process||err=%v", err)
+	}
+
+	//收到 SIGINT/SIGTERM 之后停止接受新连接，等待正在处理的请求结束；收到 SIGHUP 重新加载 TLS 证书和限流规则
+	//收到 SIGUSR2 时热重启：启动新进程并交出监听 socket，新进程开始服务之后会发 SIGTERM 让当前进程退出
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
 
-	// thrift 服务启动
-	protocolFactory := thrift.NewTBinaryProtocolFactoryDefault()
-	transportFactory := thrift.NewTTransportFactory()
-	secure := false
-	if err := runServer(transportFactory, protocolFactory, ADDR, secure); err != nil {
-		fmt.Println("error running server:", err)
+	for {
+		select {
+		case err := <-serveErr:
+			if err != nil {
+				fmt.Println("error running server:", err)
+				log.Errorf("main||error running server||err=%v", err)
+			}
+			return
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				reloadTLS(tlsReloader)
+				reloadRateLimit(limiter)
+				continue
+			}
+			if sig == syscall.SIGUSR2 {
+				restart(listeners)
+				continue
+			}
+
+			log.Infof("main||receive signal, shutting down||signal=%v", sig)
+			drainTimeout := time.Duration(config.ServerConf.DrainTimeoutMS) * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			if httpSvr != nil {
+				go httpSvr.Shutdown(ctx)
+			}
+			if err := svr.Shutdown(ctx); err != nil {
+				log.Warnf("main||drain timeout, force closing connections||timeout=%v||err=%v", drainTimeout, err)
+			}
+			<-serveErr
+			<-httpDone
+			cancel()
+			log.Infof("main||server stopped")
+			return
+		}
 	}
 }
 
-func runServer(transportFactory thrift.TTransportFactory, protocolFactory thrift.TProtocolFactory, addr string, secure bool) error {
-	var transport thrift.TServerTransport
+func newServer(serverConf conf.ServerConf, processorFactory thrift.TProcessorFactory, tlsReloader *server.TLSReloader, listeners *server.ListenerSet) (*server.Server, error) {
+	//protocol 为 auto 时每个连接自动识别协议和 transport，transport 配置不生效
+	detect := strings.EqualFold(serverConf.Protocol, server.ProtocolAuto)
+	var protocolFactory thrift.TProtocolFactory
 	var err error
-	if secure {
-		cfg := new(tls.Config)
-		if cert, err := tls.LoadX509KeyPair("server.crt", "server.key"); err == nil {
-			cfg.Certificates = append(cfg.Certificates, cert)
-		} else {
-			return err
-		}
-		transport, err = thrift.NewTSSLServerSocket(addr, cfg)
-	} else {
-		transport, err = thrift.NewTServerSocket(addr)
+	if !detect {
+		if protocolFactory, err = server.NewProtocolFactory(serverConf.Protocol); err != nil {
+			return nil, err
+		}
+	}
+	transportFactory, err := server.NewTransportFactory(server.TransportOptions{
+		Transport:    serverConf.Transport,
+		BufferSize:   serverConf.BufferSize,
+		Zlib:         serverConf.Zlib,
+		ZlibLevel:    serverConf.ZlibLevel,
+		MaxFrameSize: serverConf.MaxFrameSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := listeners.Listen("thrift", "tcp", serverConf.Addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tlsReloader != nil {
+		ln = tls.NewListener(ln, tlsReloader.Config())
+	}
+	if serverConf.Unix.Enabled {
+		unixLn, err := listeners.Listen("unix", "unix", serverConf.Unix.Path, func() (net.Listener, error) {
+			return listenUnix(serverConf.Unix)
+		})
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		fmt.Println("Starting the server... on ", serverConf.Unix.Path)
+		ln = server.NewMultiListener(ln, unixLn)
+	}
+	transport := server.NewServerSocket(ln, 0)
+
+	svr := server.NewServerFactory6(processorFactory, transport, transportFactory, transportFactory, protocolFactory, protocolFactory, server.Options{
+		MaxConns: serverConf.MaxConns,
+		Workers:  serverConf.Workers,
+		Backlog:  serverConf.Backlog,
+		Detect:   detect,
+
+		IdleTimeout:  time.Duration(serverConf.IdleTimeoutMS) * time.Millisecond,
+		ReadTimeout:  time.Duration(serverConf.ReadTimeoutMS) * time.Millisecond,
+		WriteTimeout: time.Duration(serverConf.WriteTimeoutMS) * time.Millisecond,
+
+		MaxFrameSize:     serverConf.MaxFrameSize,
+		MaxMessageSize:   serverConf.MaxMessageSize,
+		MaxStringSize:    serverConf.MaxStringSize,
+		MaxContainerSize: serverConf.MaxContainerSize,
+	})
+	return svr, nil
+}
+
+func newRegistry(config conf.Config, limiter *ratelimit.Limiter, overloadLimiter *overload.Limiter) (*server.Registry, error) {
+	registry := server.NewRegistry()
+	chain, err := newChain(config, limiter, overloadLimiter)
+	if err != nil {
+		return nil, err
+	}
+	newService := func(ctx context.Context) idl.Php_Go_Svr {
+		return service.NewWithContext(ctx)
+	}
+	err = registry.RegisterDefault(service.Name, func(ctx context.Context) thrift.TProcessor {
+		return idl.NewPhp_Go_SvrProcessor(middleware.NewPhpGoSvr(ctx, service.Name, newService, chain))
+	})
+	return registry, err
+}
+
+//每个请求依次经过 chain 中的中间件，再交给 service 处理
+func newChain(config conf.Config, limiter *ratelimit.Limiter, overloadLimiter *overload.Limiter) (middleware.Middleware, error) {
+	middlewares := []middleware.Middleware{
+		middleware.Trace(),
+		middleware.Metrics(),
+		middleware.AccessLog(),
+		//Recover 在 Metrics 和 AccessLog 里面，panic 的调用按 exception 统计和输出 access log
+		middleware.Recover(),
+		//已经超时的请求在认证、限流和并发限制之前拒绝，不占用令牌和并发名额
+		middleware.Deadline(),
+	}
+	if config.AuthConf.Enabled {
+		authOptions, err := newAuthOptions(config.AuthConf)
+		if err != nil {
+			return nil, err
+		}
+		middlewares = append(middlewares, middleware.Auth(authOptions))
 	}
+	if config.AuthzConf.Enabled {
+		authorizeOptions, err := newAuthorizeOptions(config.AuthzConf)
+		if err != nil {
+			return nil, err
+		}
+		middlewares = append(middlewares, middleware.Authorize(authorizeOptions))
+	}
+	if limiter != nil {
+		middlewares = append(middlewares, middleware.RateLimit(limiter))
+	}
+	if overloadLimiter != nil {
+		middlewares = append(middlewares, middleware.Overload(overloadLimiter))
+	}
+	if config.SlowLogConf.Enabled {
+		middlewares = append(middlewares, middleware.SlowLog(newSlowLogOptions(config.SlowLogConf)))
+	}
+	middlewares = append(middlewares, middleware.Log())
+	return middleware.Chain(middlewares...), nil
+}
+
+func newAuthOptions(authConf conf.AuthConf) (middleware.AuthOptions, error) {
+	apps := make(map[string]auth.App, len(authConf.Apps))
+	for appID, app := range authConf.Apps {
+		if app.Secret == "" {
+			return middleware.AuthOptions{}, fmt.Errorf("empty secret for app %q", appID)
+		}
+		apps[appID] = auth.App{Secret: app.Secret, MaxQPS: app.MaxQPS}
+	}
+	return middleware.AuthOptions{
+		Verifier: auth.NewVerifier(apps, time.Duration(authConf.WindowSec)*time.Second),
+		Required: authConf.Required,
+	}, nil
+}
+
+func newAuthorizeOptions(authzConf conf.AuthzConf) (middleware.AuthorizeOptions, error) {
+	roles := make(map[string]authz.Members, len(authzConf.Roles))
+	for name, role := range authzConf.Roles {
+		roles[name] = authz.Members{
+			CIDRs:      role.CIDRs,
+			Identities: role.Identities,
+			AppIDs:     role.AppIDs,
+		}
+	}
+	policy, err := authz.NewPolicy(roles, authzConf.Methods, service.Name)
+	if err != nil {
+		return middleware.AuthorizeOptions{}, err
+	}
+	return middleware.AuthorizeOptions{
+		Policy:      policy,
+		AuditLogger: log.New(authzConf.AuditFilePath),
+	}, nil
+}
+
+//没有开启限流时返回 nil
+func newRateLimiter(rateLimitConf conf.RateLimitConf) (*ratelimit.Limiter, error) {
+	if !rateLimitConf.Enabled {
+		return nil, nil
+	}
+	return ratelimit.New(rateLimitRules(rateLimitConf))
+}
+
+func rateLimitRules(rateLimitConf conf.RateLimitConf) []ratelimit.Rule {
+	rules := make([]ratelimit.Rule, 0, len(rateLimitConf.Rules))
+	for _, rule := range rateLimitConf.Rules {
+		rules = append(rules, ratelimit.Rule{
+			Method: rule.Method,
+			Caller: rule.Caller,
+			Rate:   rule.Rate,
+			Burst:  rule.Burst,
+			Shared: rule.Shared,
+		})
+	}
+	return rules
+}
+
+func newOverloadLimiter(overloadConf conf.OverloadConf) *overload.Limiter {
+	return overload.New(overload.Options{
+		InitialLimit:  overloadConf.InitialLimit,
+		MinLimit:      overloadConf.MinLimit,
+		MaxLimit:      overloadConf.MaxLimit,
+		LatencyTarget: time.Duration(overloadConf.LatencyTargetMS) * time.Millisecond,
+		Backoff:       overloadConf.Backoff,
+		ShadowRatio:   overload.Ratio(overloadConf.ShadowRatio),
+	})
+}
+
+func newSlowLogOptions(slowLogConf conf.SlowLogConf) middleware.SlowLogOptions {
+	thresholds := make(map[string]time.Duration, len(slowLogConf.MethodThresholdMS))
+	for method, ms := range slowLogConf.MethodThresholdMS {
+		thresholds[method] = time.Duration(ms) * time.Millisecond
+	}
+	return middleware.SlowLogOptions{
+		Logger:           log.New(slowLogConf.FilePath),
+		Threshold:        time.Duration(slowLogConf.ThresholdMS) * time.Millisecond,
+		MethodThresholds: thresholds,
+		Debug:            slowLogConf.Debug,
+	}
+}
 
+func listenUnix(unixConf conf.UnixConf) (net.Listener, error) {
+	mode, err := strconv.ParseUint(unixConf.Mode, 8, 32)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid unix socket mode %q", unixConf.Mode)
+	}
+	return server.ListenUnix(server.UnixOptions{
+		Path:  unixConf.Path,
+		Mode:  os.FileMode(mode),
+		Owner: unixConf.Owner,
+		Group: unixConf.Group,
+	})
+}
+
+func newHTTPServer(httpConf conf.HTTPConf, processorFactory thrift.TProcessorFactory) *http.Server {
+	return server.NewHTTPServer(httpConf.Addr, processorFactory, server.HTTPOptions{
+		Path:         httpConf.Path,
+		ReadTimeout:  time.Duration(httpConf.ReadTimeoutMS) * time.Millisecond,
+		WriteTimeout: time.Duration(httpConf.WriteTimeoutMS) * time.Millisecond,
+		IdleTimeout:  time.Duration(httpConf.IdleTimeoutMS) * time.Millisecond,
+		MaxBodySize:  httpConf.MaxBodyBytes,
+	})
+}
+
+func newAdminServer(config conf.Config, svr *server.Server, limiter *ratelimit.Limiter, overloadLimiter *overload.Limiter) *http.Server {
+	var statuses []admin.Status
+	if limiter != nil {
+		statuses = append(statuses, admin.Status{Name: "ratelimit", Status: func() interface{} {
+			return limiter.Status()
+		}})
+	}
+	if overloadLimiter != nil {
+		statuses = append(statuses, admin.Status{Name: "overload", Status: func() interface{} {
+			return overloadLimiter.Status()
+		}})
 	}
-	//fmt.Printf("%T\n", transport)
+	if config.RedisConf.Breaker.Enabled {
+		statuses = append(statuses, admin.Status{Name: "redis_breaker", Status: func() interface{} {
+			return rpc.BreakerStatuses()
+		}})
+	}
+	handler := admin.NewHandler(admin.Options{
+		Config: config,
+		Checks: []admin.Check{
+			{Name: "redis", Check: rpc.Ping},
+			{Name: "thrift", Check: func(ctx context.Context) error {
+				if !svr.Accepting() {
+					return errors.New("not accepting connections")
+				}
+				return nil
+			}},
+		},
+		Statuses: statuses,
+	})
+	//pprof 的 profile 默认采样 30 秒，写超时要比它长
+	return &http.Server{
+		Addr:         config.AdminConf.Addr,
+		Handler:      handler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 60 * time.Second,
+	}
+}
 
-	handler := service.New()
-	processor := idl.NewPhp_Go_SvrProcessor(handler)
-	server := thrift.NewTSimpleServer4(processor, transport, transportFactory, protocolFactory)
-	fmt.Println("Starting the simple server... on ", addr)
-	return server.Serve()
+//连接数、违规次数、并发上限和 goroutine 数，RPC 和 Redis 的指标由 middleware 和 rpc 包自己注册
+func registerMetrics(svr *server.Server, overloadLimiter *overload.Limiter) {
+	metrics.Default.NewCollector("php_go_server_conns", "Active thrift connections.", "gauge", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(svr.Conns()))
+		})
+	metrics.Default.NewCollector("php_go_server_accepted_total", "Total accepted thrift connections.", "counter", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(svr.Accepted()))
+		})
+	metrics.Default.NewCollector("php_go_server_rejected_total", "Total connections rejected because the server was busy.", "counter", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(svr.Rejected()))
+		})
+	metrics.Default.NewCollector("php_go_server_violations_total", "Total connections closed for timeout or size limit violations.", "counter",
+		[]string{"kind"}, func(emit func(float64, ...string)) {
+			violations := svr.Violations()
+			for _, kind := range server.ViolationKinds {
+				emit(float64(violations.Get(kind)), kind)
+			}
+		})
+	if overloadLimiter != nil {
+		metrics.Default.NewCollector("php_go_overload_limit", "Current adaptive concurrency limit.", "gauge", nil,
+			func(emit func(float64, ...string)) {
+				emit(overloadLimiter.Status().Limit)
+			})
+		metrics.Default.NewCollector("php_go_overload_inflight", "RPC calls being handled under the concurrency limit.", "gauge", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(overloadLimiter.Status().Inflight))
+			})
+	}
+	metrics.Default.NewCollector("go_goroutines", "Number of goroutines.", "gauge", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(runtime.NumGoroutine()))
+		})
+}
+
+func newTLSReloader(tlsConf conf.TLSConf) (*server.TLSReloader, error) {
+	return server.NewTLSReloader(server.TLSOptions{
+		CertFile:       tlsConf.CertFile,
+		KeyFile:        tlsConf.KeyFile,
+		MinVersion:     tlsConf.MinVersion,
+		CipherSuites:   tlsConf.CipherSuites,
+		ClientAuth:     tlsConf.ClientAuth,
+		ClientCAFile:   tlsConf.ClientCAFile,
+		ReloadInterval: time.Duration(tlsConf.ReloadIntervalMS) * time.Millisecond,
+	})
+}
+
+func restart(listeners *server.ListenerSet) {
+	process, err := listeners.StartProcess()
+	if err != nil {
+		log.Errorf("main||fail to start new process||err=%v", err)
+		return
+	}
+	//新进程启动失败时当前进程继续服务
+	go func() {
+		state, err := process.Wait()
+		log.Warnf("main||new process exited||pid=%v||state=%v||err=%v", process.Pid, state, err)
+	}()
+}
+
+//配置文件有错误时继续使用原来的规则
+func reloadRateLimit(limiter *ratelimit.Limiter) {
+	if limiter == nil {
+		return
+	}
+	rateLimitConf, err := conf.LoadRateLimitConf(configFile)
+	if err == nil {
+		err = limiter.Update(rateLimitRules(rateLimitConf))
+	}
+	if err != nil {
+		log.Errorf("main||fail to reload rate limit rules||err=%v", err)
+		return
+	}
+	log.Infof("main||rate limit rules reloaded by SIGHUP||rules=%v", len(rateLimitConf.Rules))
+}
+
+func reloadTLS(tlsReloader *server.TLSReloader) {
+	if tlsReloader == nil {
+		return
+	}
+	if err := tlsReloader.Reload(); err != nil {
+		log.Errorf("main||fail to reload tls certificate||err=%v", err)
+		return
+	}
+	log.Infof("main||tls certificate reloaded by SIGHUP")
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+	"php-thrift-go-server/client"
+	"php-thrift-go-server/conf"
+	"php-thrift-go-server/metrics"
+	"php-thrift-go-server/middleware"
+	"php-thrift-go-server/ratelimit"
+	"php-thrift-go-server/service"
+)
+
+// 用 main 中组装的 chain 调用 nil 请求，panic 要被 Recover 恢复成错误，不能从 chain 中逃出去。
+func TestChainRecoversNilRequest(t *testing.T) {
+	config := conf.DefaultConfig()
+	chain, err := newChain(config, nil, nil)
+
+	if err != nil {
+		t.Fatalf("fail to create chain. [err:%v]", err)
+	}
+
+	newService := func(ctx context.Context) idl.Php_Go_Svr {
+		return service.NewWithContext(ctx)
+	}
+
+	svr := middleware.NewPhpGoSvr(context.Background(), service.Name, newService, chain)
+
+	defer func() {
+		if e := recover(); e != nil {
+			t.Fatalf("panic escaped chain: %v", e)
+		}
+	}()
+
+	before := requestCount(t, "SetUsers", "exception")
+
+	if _, err := svr.GetUserByUserID(nil); err == nil {
+		t.Fatalf("nil request must return an error.")
+	}
+
+	if _, err := svr.SetUsers(nil); err == nil {
+		t.Fatalf("nil request must return an error.")
+	}
+
+	// Recover 在 Metrics 里面，panic 的调用也要统计。
+	if after := requestCount(t, "SetUsers", "exception"); after != before+1 {
+		t.Fatalf("panicking call must be counted. [before:%v] [after:%v]", before, after)
+	}
+}
+
+// requestCount 返回 metrics.Default 中 php_go_rpc_requests_total 的值，没有这一行时是 0。
+// metrics.Default 中有 Redis 连接池的指标，没有连接 Redis 时用一个不会连接的 client。
+func requestCount(t *testing.T, method, code string) int64 {
+	if client.RedisClient.Options() == nil {
+		client.RedisClient = *redis.NewClient(&redis.Options{})
+	}
+
+	buf := &bytes.Buffer{}
+	metrics.Default.WriteTo(buf)
+	prefix := `php_go_rpc_requests_total{service="` + service.Name + `",method="` + method + `",code="` + code + `"} `
+
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, prefix), 10, 64)
+
+			if err != nil {
+				t.Fatalf("invalid metric. [line:%v] [err:%v]", line, err)
+			}
+
+			return n
+		}
+	}
+
+	return 0
+}
+
+// 已经超时的请求要在限流之前被拒绝，不能占用令牌。
+func TestChainRejectsExpiredBeforeRateLimit(t *testing.T) {
+	limiter, err := ratelimit.New([]ratelimit.Rule{{Method: ratelimit.Any, Caller: ratelimit.Any, Rate: 1}})
+
+	if err != nil {
+		t.Fatalf("fail to create limiter. [err:%v]", err)
+	}
+
+	chain, err := newChain(conf.DefaultConfig(), limiter, nil)
+
+	if err != nil {
+		t.Fatalf("fail to create chain. [err:%v]", err)
+	}
+
+	newService := func(ctx context.Context) idl.Php_Go_Svr {
+		return service.NewWithContext(ctx)
+	}
+
+	svr := middleware.NewPhpGoSvr(context.Background(), service.Name, newService, chain)
+	expired := map[string]string{"timeout": "1000000", "elapsed_time": "2000000"}
+
+	for i := 0; i < 3; i++ {
+		resp, err := svr.SetUsers(&idl.SetUsersReq{UserInfoStr: "[]", Trace: expired})
+
+		if err != nil || resp.Header.Code != service.CodeDeadlineExceeded {
+			t.Fatalf("expired request must be rejected. [resp:%v] [err:%v]", resp, err)
+		}
+	}
+
+	if buckets := limiter.Status().Buckets; len(buckets) != 0 {
+		t.Fatalf("expired requests must not take rate limit tokens. [buckets:%+v]", buckets)
+	}
+}
@@ -0,0 +1,335 @@
+// Package metrics 实现了 Prometheus 文本格式的监控指标，只包含服务需要的 counter、histogram 和 collector，
+// 不依赖 Prometheus 的 client 库。
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets 是 histogram 的默认分桶，单位是秒，覆盖 1ms 到 10s。
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default 是进程默认的 Registry，admin 端口的 /metrics 输出它的内容。
+var Default = NewRegistry()
+
+// Registry 保存所有指标，按注册顺序输出。
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+// NewRegistry 创建一个空的 Registry。
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo 把所有指标以 Prometheus 文本格式写到 w。
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+
+	for _, m := range metrics {
+		m.write(buf)
+	}
+
+	return buf.WriteTo(w)
+}
+
+// Handler 返回输出所有指标的 http.Handler。
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// desc 是指标的名字、说明和 label 名。
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// writeSample 输出一行数据，extra 是 histogram 的 le 这类额外的 label。
+func (d *desc) writeSample(buf *bytes.Buffer, suffix string, values []string, extra string, value float64) {
+	buf.WriteString(d.name)
+	buf.WriteString(suffix)
+
+	if len(values) > 0 || extra != "" {
+		buf.WriteByte('{')
+
+		for i, v := range values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			fmt.Fprintf(buf, "%s=\"%s\"", d.labels[i], escapeLabel(v))
+		}
+
+		if extra != "" {
+			if len(values) > 0 {
+				buf.WriteByte(',')
+			}
+
+			buf.WriteString(extra)
+		}
+
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+// vec 保存一个指标所有 label 组合的数据。
+type vec struct {
+	desc
+	mu       sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+	create   func() interface{}
+}
+
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %v expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+
+	if ok {
+		return child
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if child, ok = v.children[key]; !ok {
+		child = v.create()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+
+	return child
+}
+
+// each 按 label 排序遍历所有数据，保证输出稳定。
+func (v *vec) each(fn func(values []string, child interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		child, values := v.children[key], v.values[key]
+		v.mu.RUnlock()
+		fn(values, child)
+	}
+}
+
+func newVec(name, help, typ string, labels []string, create func() interface{}) *vec {
+	return &vec{
+		desc:     desc{name: name, help: help, typ: typ, labels: labels},
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+		create:   create,
+	}
+}
+
+// Counter 是只增不减的计数。
+type Counter struct {
+	value int64
+}
+
+// Inc 加 1。
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+// Add 增加 n，n 不能是负数。
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.value, n)
+}
+
+// Value 返回当前值。
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+// CounterVec 是按 label 区分的一组 Counter。
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec 创建并注册一个 CounterVec。
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() interface{} { return &Counter{} })}
+	r.register(c)
+	return c
+}
+
+// With 返回 label 值对应的 Counter，label 值的顺序与创建时的 label 名一致。
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values).(*Counter)
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+	c.writeHeader(buf)
+	c.each(func(values []string, child interface{}) {
+		c.writeSample(buf, "", values, "", float64(child.(*Counter).Value()))
+	})
+}
+
+// Histogram 统计数据的分布，比如请求耗时。
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe 记录一个数据。
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// HistogramVec 是按 label 区分的一组 Histogram。
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec 创建并注册一个 HistogramVec，buckets 为 nil 时使用 DefaultBuckets。
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labels, func() interface{} {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+	r.register(h)
+	return h
+}
+
+// With 返回 label 值对应的 Histogram。
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values).(*Histogram)
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	h.writeHeader(buf)
+	h.each(func(values []string, child interface{}) {
+		histogram := child.(*Histogram)
+		histogram.mu.Lock()
+		counts := append([]uint64(nil), histogram.counts...)
+		count, sum := histogram.count, histogram.sum
+		histogram.mu.Unlock()
+
+		var cumulative uint64
+
+		for i, upper := range h.buckets {
+			cumulative += counts[i]
+			h.writeSample(buf, "_bucket", values, `le="`+formatFloat(upper)+`"`, float64(cumulative))
+		}
+
+		h.writeSample(buf, "_bucket", values, `le="+Inf"`, float64(count))
+		h.writeSample(buf, "_sum", values, "", sum)
+		h.writeSample(buf, "_count", values, "", float64(count))
+	})
+}
+
+// Collector 在输出时才读取数据，用于已经在别处统计好的值，比如连接数、Redis 连接池状态。
+type Collector struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewCollector 创建并注册一个 Collector，typ 是 counter 或者 gauge。
+// 每次输出时调用 collect，collect 通过 emit 输出每个 label 组合的值。
+func (r *Registry) NewCollector(name, help, typ string, labels []string, collect func(emit func(value float64, labelValues ...string))) *Collector {
+	c := &Collector{desc: desc{name: name, help: help, typ: typ, labels: labels}, collect: collect}
+	r.register(c)
+	return c
+}
+
+func (c *Collector) write(buf *bytes.Buffer) {
+	c.writeHeader(buf)
+	c.collect(func(value float64, values ...string) {
+		c.writeSample(buf, "", values, "", value)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("rpc_requests_total", "Total RPC requests.", "method", "code")
+	duration := r.NewHistogramVec("rpc_duration_seconds", "RPC latency.", []float64{1, 0.1}, "method")
+	r.NewCollector("conns", "Active connections.", "gauge", nil, func(emit func(float64, ...string)) {
+		emit(3)
+	})
+
+	requests.With("SetUsers", "0").Inc()
+	requests.With("SetUsers", "0").Add(2)
+	requests.With("GetUserByUserID", `a"b`).Inc()
+	duration.With("SetUsers").Observe(0.25)
+	duration.With("SetUsers").Observe(0.5)
+	duration.With("SetUsers").Observe(5)
+
+	buf := &bytes.Buffer{}
+	r.WriteTo(buf)
+	expected := `# HELP rpc_requests_total Total RPC requests.
+# TYPE rpc_requests_total counter
+rpc_requests_total{method="GetUserByUserID",code="a\"b"} 1
+rpc_requests_total{method="SetUsers",code="0"} 3
+# HELP rpc_duration_seconds RPC latency.
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{method="SetUsers",le="0.1"} 0
+rpc_duration_seconds_bucket{method="SetUsers",le="1"} 2
+rpc_duration_seconds_bucket{method="SetUsers",le="+Inf"} 3
+rpc_duration_seconds_sum{method="SetUsers"} 5.75
+rpc_duration_seconds_count{method="SetUsers"} 3
+# HELP conns Active connections.
+# TYPE conns gauge
+conns 3
+`
+
+	if actual := buf.String(); actual != expected {
+		t.Fatalf("invalid output.\n[expected:%v]\n[actual:%v]", expected, actual)
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"php-thrift-go-server/server"
+)
+
+// accessLogTag 是 access log 在 public.log 中的 tag，Ultron 压测流量会自动加上 _shadow 后缀。
+const accessLogTag = "php_go_access"
+
+// publicLog 输出 public.log，测试时替换。
+var publicLog = log.Public
+
+// AccessLog 为每次调用在 public.log 中写一行标准格式的日志，用来计算 SLI，不包含请求和响应的内容。
+// 日志包括方法、调用方、认证过的 app id、客户端地址、耗时、ResponseHeader.Code、请求和响应在连接上的字节数和 trace 信息。
+// 在 Server 处理的连接上，日志在响应写完之后输出，耗时包括写响应的时间；thrift over HTTP 时没有字节数。
+// AccessLog 要放在 Recover 前面，panic 的调用也输出一行，code 是 exception。
+func AccessLog() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			result, err := next(ctx, call)
+			code := ResponseCode(result, err)
+
+			write := func(requestBytes, responseBytes int64) {
+				kvs := map[string]interface{}{
+					"service":    call.Service,
+					"method":     call.Method,
+					"caller":     Caller(ctx),
+					"peer":       peer(ctx),
+					"code":       code,
+					"latency_ms": float64(call.Elapsed().Nanoseconds()) / 1e6,
+				}
+
+				if call.AppID != "" {
+					kvs["app_id"] = call.AppID
+				}
+
+				if requestBytes >= 0 {
+					kvs["request_bytes"] = requestBytes
+					kvs["response_bytes"] = responseBytes
+				}
+
+				if err != nil {
+					kvs["err"] = err.Error()
+				}
+
+				publicLog(ctx, accessLogTag, kvs)
+			}
+
+			if !server.AfterResponse(ctx, write) {
+				write(-1, -1)
+			}
+
+			return result, err
+		}
+	}
+}
+
+// peer 返回客户端地址。
+func peer(ctx context.Context) string {
+	if info := server.ConnInfoFromContext(ctx); info != nil {
+		return info.RemoteAddr
+	}
+
+	return ""
+}
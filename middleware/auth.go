@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+	"php-thrift-go-server/auth"
+	"php-thrift-go-server/service"
+)
+
+// AuthOptions 是调用方认证的配置。
+type AuthOptions struct {
+	Verifier *auth.Verifier
+	Required bool // 为 false 时没有带签名的请求也可以调用，方便调用方逐步接入；带了签名就必须校验通过。
+}
+
+// Auth 校验请求中 auth 字段的签名，失败时返回 service.CodeUnauthenticated，不调用业务代码。
+// 签名正确但是调用方在时间窗口内的请求超过了 nonce 缓存的容量时返回 service.CodeRateLimited，其他调用方不受影响。
+// 校验通过之后调用方的 app id 放到 call.AppID 和 ctx 中，业务代码可以用 auth.AppID(ctx) 拿到，
+// Caller 也会优先返回 app id。
+func Auth(opts AuthOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			req, _ := call.Args.(interface {
+				GetAuth() *idl.Auth
+				GetTrace() map[string]string
+			})
+
+			if req == nil || req.GetAuth() == nil {
+				if !opts.Required {
+					return next(ctx, call)
+				}
+
+				return reject(ctx, call, "", auth.ErrMissing)
+			}
+
+			a := req.GetAuth()
+			payload, err := signedPayload(call.Args)
+
+			if err == nil {
+				err = opts.Verifier.Verify(a.AppId, auth.Message{
+					Method:    call.Method,
+					Timestamp: a.Timestamp,
+					Nonce:     a.Nonce,
+					Trace:     req.GetTrace(),
+					Payload:   payload,
+				}, a.Signature)
+			}
+
+			if err == auth.ErrTooManyNonces {
+				log.Warnf("Auth||%v||%v||too many signed requests in window||caller=%v||app_id=%v", call.Method, trace.ContextString(ctx), Caller(ctx), a.AppId)
+				return errorResponse(call, service.CodeRateLimited, "rate limited: "+err.Error()), nil
+			}
+
+			if err != nil {
+				return reject(ctx, call, a.AppId, err)
+			}
+
+			call.AppID = a.AppId
+			return next(auth.NewContext(ctx, a.AppId), call)
+		}
+	}
+}
+
+func reject(ctx context.Context, call *Call, appID string, err error) (interface{}, error) {
+	log.Warnf("Auth||%v||%v||reject unauthenticated request||caller=%v||app_id=%v||err=%v", call.Method, trace.ContextString(ctx), Caller(ctx), appID, err)
+	return errorResponse(call, service.CodeUnauthenticated, "unauthenticated: "+err.Error()), nil
+}
+
+// signedPayload 返回请求去掉 auth 和 trace 之后的 binary 序列化，trace 是 map，序列化的顺序不固定，
+// 由 auth.Sign 按 key 排序之后单独签名。
+func signedPayload(args interface{}) ([]byte, error) {
+	var req thrift.TStruct
+
+	switch args := args.(type) {
+	case *idl.GetUserByIdReq:
+		copied := *args
+		copied.Auth, copied.Trace = nil, nil
+		req = &copied
+	case *idl.SetUsersReq:
+		copied := *args
+		copied.Auth, copied.Trace = nil, nil
+		req = &copied
+	default:
+		return nil, fmt.Errorf("unknown request %T", args)
+	}
+
+	return thrift.NewTSerializer().Write(req)
+}
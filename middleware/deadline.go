@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"php-thrift-go-server/service"
+)
+
+// Deadline 在调用业务代码之前检查调用方的超时时间，已经超时的请求直接返回 service.CodeDeadlineExceeded，
+// 不再做 PHP 端已经放弃的工作。超时时间来自 trace 中的 timeout，所以 Deadline 要放在 Trace 后面；
+// 同时要放在 Auth、RateLimit 和 Overload 前面，已经超时的请求不应该占用令牌和并发名额。
+func Deadline() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			// trace 的 timer 有精度误差，直接比较 deadline。
+			if deadline, ok := ctx.Deadline(); ctx.Err() != nil || (ok && !time.Now().Before(deadline)) {
+				log.Warnf("Service||%v||%v||reject expired request||caller=%v||deadline=%v", call.Method, trace.ContextString(ctx), Caller(ctx), deadline)
+				return errorResponse(call, service.CodeDeadlineExceeded, "deadline exceeded"), nil
+			}
+
+			return next(ctx, call)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"php-thrift-go-server/auth"
+	"php-thrift-go-server/server"
+	"php-thrift-go-server/util"
+)
+
+// Log 记录每次调用的方法、调用方、请求、响应、错误和耗时。
+// 每次调用的标准日志由 AccessLog 输出，这里只在出错时输出 Error 日志，完整的请求和响应只在 DEBUG 级别输出。
+func Log() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			result, err := next(ctx, call)
+
+			if err != nil {
+				log.Errorf("Service||%v||%v||caller=%v||req=%v||err=%v||cost=%v", call.Method, trace.ContextString(ctx), Caller(ctx), util.JsonString(call.Args), err, call.Elapsed())
+			} else {
+				log.Debugf("Service||%v||%v||caller=%v||req=%v||resp=%v||cost=%v", call.Method, trace.ContextString(ctx), Caller(ctx), util.JsonString(call.Args), util.JsonString(result), call.Elapsed())
+			}
+
+			return result, err
+		}
+	}
+}
+
+// Caller 返回调用方的身份，优先使用签名认证过的 app id，其次是校验过的客户端证书，最后是客户端地址。
+func Caller(ctx context.Context) string {
+	if appID := auth.AppID(ctx); appID != "" {
+		return appID
+	}
+
+	info := server.ConnInfoFromContext(ctx)
+
+	if info == nil {
+		return ""
+	}
+
+	if id := info.ClientIdentity(); id != "" {
+		return id
+	}
+
+	return info.RemoteAddr
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+
+	"php-thrift-go-server/metrics"
+)
+
+// codeException 表示调用返回了错误，客户端收到的是 thrift 异常而不是带 code 的响应。
+const codeException = "exception"
+
+var (
+	rpcRequests = metrics.Default.NewCounterVec("php_go_rpc_requests_total",
+		"Total RPC calls by method and ResponseHeader.Code.", "service", "method", "code")
+	rpcDuration = metrics.Default.NewHistogramVec("php_go_rpc_duration_seconds",
+		"RPC latency in seconds.", nil, "service", "method")
+)
+
+// Metrics 统计每个方法的调用次数、耗时和 ResponseHeader.Code 的分布。
+func Metrics() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			result, err := next(ctx, call)
+			rpcRequests.With(call.Service, call.Method, ResponseCode(result, err)).Inc()
+			rpcDuration.With(call.Service, call.Method).Observe(call.Elapsed().Seconds())
+			return result, err
+		}
+	}
+}
+
+// ResponseCode 返回调用结果的 ResponseHeader.Code，返回错误时是 exception。
+func ResponseCode(result interface{}, err error) string {
+	if err != nil {
+		return codeException
+	}
+
+	if header := responseHeader(result); header != nil {
+		return strconv.Itoa(int(header.Code))
+	}
+
+	return ""
+}
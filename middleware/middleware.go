@@ -0,0 +1,39 @@
+// Package middleware 提供 thrift handler 的中间件链，恢复 panic、日志、监控、鉴权、限流等
+// 通用逻辑写成 Middleware，按顺序包在业务 handler 外面，不再需要在每个方法里重复。
+package middleware
+
+import (
+	"context"
+	"time"
+)
+
+// Call 描述一次 RPC 调用。
+type Call struct {
+	Service string
+	Method  string
+	Args    interface{} // 解码之后的请求，比如 *idl.GetUserByIdReq。
+	Start   time.Time
+	AppID   string // 通过签名认证的调用方 app id，由 Auth 设置，外层的中间件在 next 返回之后也能拿到。
+}
+
+// Elapsed 返回调用开始到现在的时间。
+func (c *Call) Elapsed() time.Duration {
+	return time.Since(c.Start)
+}
+
+// Handler 处理一次调用，返回的 result 是响应，比如 *idl.GetUserByIdResp。
+type Handler func(ctx context.Context, call *Call) (result interface{}, err error)
+
+// Middleware 包装一个 Handler，可以在调用前后做处理，也可以直接返回不再调用 next。
+type Middleware func(next Handler) Handler
+
+// Chain 把多个 Middleware 串起来，第一个在最外层，也就是最先执行。
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+
+		return next
+	}
+}
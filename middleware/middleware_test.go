@@ -0,0 +1,561 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+	"php-thrift-go-server/auth"
+	"php-thrift-go-server/authz"
+	"php-thrift-go-server/overload"
+	"php-thrift-go-server/ratelimit"
+	"php-thrift-go-server/server"
+	"php-thrift-go-server/service"
+	"php-thrift-go-server/timing"
+)
+
+type testHandler struct{}
+
+func (testHandler) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	return &idl.GetUserByIdResp{User: &idl.UserInfo{UserID: req.UserID}}, nil
+}
+
+func (testHandler) SetUsers(req *idl.SetUsersReq) (*idl.SetUsersResp, error) {
+	return nil, errors.New("set users error")
+}
+
+type ctxKey struct{}
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, call *Call) (interface{}, error) {
+				order = append(order, name+":"+call.Method)
+				return next(context.WithValue(ctx, ctxKey{}, name), call)
+			}
+		}
+	}
+
+	var handlerCtx context.Context
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		handlerCtx = ctx
+		return testHandler{}
+	}
+
+	var result interface{}
+	var resultErr error
+	inspect := func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			result, resultErr = next(ctx, call)
+			return result, resultErr
+		}
+	}
+
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(record("a"), record("b"), inspect))
+	resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 7})
+
+	if err != nil || resp.User.UserID != 7 {
+		t.Fatalf("fail to call GetUserByUserID. [resp:%v] [err:%v]", resp, err)
+	}
+
+	if len(order) != 2 || order[0] != "a:GetUserByUserID" || order[1] != "b:GetUserByUserID" {
+		t.Fatalf("invalid middleware order. [order:%v]", order)
+	}
+
+	if handlerCtx.Value(ctxKey{}) != "b" {
+		t.Fatalf("ctx of middleware must be passed to handler.")
+	}
+
+	if result != resp {
+		t.Fatalf("middleware must see the result.")
+	}
+
+	if _, err := svr.SetUsers(&idl.SetUsersReq{}); err == nil || resultErr != err {
+		t.Fatalf("middleware must see the error. [err:%v] [seen:%v]", err, resultErr)
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	deny := func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			return &idl.SetUsersResp{Header: &idl.ResponseHeader{Code: 403}}, nil
+		}
+	}
+
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		t.Fatalf("handler must not be called.")
+		return nil
+	}
+
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, deny)
+	resp, err := svr.SetUsers(&idl.SetUsersReq{})
+
+	if err != nil || resp.Header.Code != 403 {
+		t.Fatalf("invalid response. [resp:%v] [err:%v]", resp, err)
+	}
+}
+
+type panicHandler struct{}
+
+func (panicHandler) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	return &idl.GetUserByIdResp{User: &idl.UserInfo{UserID: req.UserID}}, nil
+}
+
+func (panicHandler) SetUsers(req *idl.SetUsersReq) (*idl.SetUsersResp, error) {
+	panic("set users panic")
+}
+
+func TestRecover(t *testing.T) {
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		return panicHandler{}
+	}
+
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Recover()))
+
+	if _, err := svr.GetUserByUserID(nil); err == nil {
+		t.Fatalf("nil dereference must be recovered as error.")
+	}
+
+	if _, err := svr.SetUsers(&idl.SetUsersReq{}); err == nil || err.Error() != "panic: set users panic" {
+		t.Fatalf("panic must be recovered as error. [err:%v]", err)
+	}
+
+	if resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.User.UserID != 1 {
+		t.Fatalf("handler must work after panic. [resp:%v] [err:%v]", resp, err)
+	}
+}
+
+type headerHandler struct {
+	testHandler
+}
+
+func (headerHandler) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	return &idl.GetUserByIdResp{Header: &idl.ResponseHeader{}, User: &idl.UserInfo{UserID: req.UserID}}, nil
+}
+
+func TestTrace(t *testing.T) {
+	var handlerCtx context.Context
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		handlerCtx = ctx
+		return headerHandler{}
+	}
+
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Trace()))
+	resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{
+		UserID: 1,
+		Trace: map[string]string{
+			"traceid": "0a0b0c0d5d8b0e2c3e5b1a2b00000001",
+			"spanid":  "1a2b3c4d5e6f7081",
+			"timeout": "1000000000",
+		},
+	})
+
+	if err != nil || resp.Header.GetTraceid() != "0a0b0c0d5d8b0e2c3e5b1a2b00000001" {
+		t.Fatalf("traceid must be echoed. [resp:%v] [err:%v]", resp, err)
+	}
+
+	if str := trace.ContextString(handlerCtx); !strings.Contains(str, "traceid=0a0b0c0d5d8b0e2c3e5b1a2b00000001") {
+		t.Fatalf("trace must be passed to handler. [actual:%v]", str)
+	}
+
+	if deadline, ok := handlerCtx.Deadline(); !ok || time.Until(deadline) > time.Second {
+		t.Fatalf("timeout of trace must be deadline of ctx. [deadline:%v] [ok:%v]", deadline, ok)
+	}
+
+	resp, err = svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+
+	if err != nil || resp.Header.GetTraceid() == "" {
+		t.Fatalf("new traceid must be generated. [resp:%v] [err:%v]", resp, err)
+	}
+
+	if _, ok := handlerCtx.Deadline(); ok {
+		t.Fatalf("ctx must not have deadline without timeout.")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	called := false
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		called = true
+		return headerHandler{}
+	}
+
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Trace(), Deadline()))
+	resp, err := svr.SetUsers(&idl.SetUsersReq{Trace: map[string]string{"timeout": "1000000", "elapsed_time": "2000000"}})
+
+	if err != nil || resp.Header.Code != service.CodeDeadlineExceeded || called {
+		t.Fatalf("expired request must be rejected. [resp:%v] [err:%v]", resp, err)
+	}
+
+	resp2, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1, Trace: map[string]string{"timeout": "1000000000"}})
+
+	if err != nil || resp2.Header.Code != 0 || !called {
+		t.Fatalf("request in time must be accepted. [resp:%v] [err:%v]", resp2, err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		return headerHandler{}
+	}
+
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Metrics()))
+	ok := rpcRequests.With("Php_Go_Svr", "GetUserByUserID", "0")
+	exception := rpcRequests.With("Php_Go_Svr", "SetUsers", codeException)
+	okBefore, exceptionBefore := ok.Value(), exception.Value()
+
+	svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+	svr.SetUsers(&idl.SetUsersReq{})
+
+	if ok.Value() != okBefore+1 || exception.Value() != exceptionBefore+1 {
+		t.Fatalf("calls must be counted by code. [ok:%v] [exception:%v]", ok.Value()-okBefore, exception.Value()-exceptionBefore)
+	}
+
+	// Recover 在 Metrics 里面，panic 的调用也要统计。
+	panicking := NewPhpGoSvr(context.Background(), "Php_Go_Svr", func(ctx context.Context) idl.Php_Go_Svr {
+		return panicHandler{}
+	}, Chain(Metrics(), Recover()))
+	exceptionBefore = exception.Value()
+
+	if _, err := panicking.SetUsers(&idl.SetUsersReq{}); err == nil {
+		t.Fatalf("panic must be recovered as error.")
+	}
+
+	if exception.Value() != exceptionBefore+1 {
+		t.Fatalf("panicking call must be counted as exception. [exception:%v]", exception.Value()-exceptionBefore)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	lines := make(chan map[string]interface{}, 1)
+	publicLog = func(ctx context.Context, tag string, kvs map[string]interface{}) {
+		if tag == accessLogTag {
+			lines <- kvs
+		}
+	}
+	defer func() {
+		publicLog = log.Public
+	}()
+
+	// 没有连接信息时立刻输出，没有字节数，panic 的调用也要输出。
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", func(ctx context.Context) idl.Php_Go_Svr {
+		return panicHandler{}
+	}, Chain(AccessLog(), Recover()))
+	svr.SetUsers(&idl.SetUsersReq{})
+	kvs := <-lines
+
+	if kvs["method"] != "SetUsers" || kvs["code"] != codeException || kvs["err"] != "panic: set users panic" {
+		t.Fatalf("panicking call must be logged. [kvs:%v]", kvs)
+	}
+
+	if _, ok := kvs["request_bytes"]; ok {
+		t.Fatalf("call without conn must not log bytes. [kvs:%v]", kvs)
+	}
+
+	// 在 Server 处理的连接上，响应写完之后输出，带上请求和响应的字节数。
+	transport, err := thrift.NewTServerSocket("127.0.0.1:0")
+
+	if err != nil || transport.Listen() != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	factory := server.ContextProcessorFactory(func(ctx context.Context) thrift.TProcessor {
+		return idl.NewPhp_Go_SvrProcessor(NewPhpGoSvr(ctx, "Php_Go_Svr", func(ctx context.Context) idl.Php_Go_Svr {
+			return headerHandler{}
+		}, Chain(AccessLog(), Recover())))
+	})
+	transportFactory, protocolFactory := thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault()
+	s := server.NewServerFactory6(factory, transport, transportFactory, transportFactory, protocolFactory, protocolFactory, server.Options{})
+	go s.Serve()
+	defer s.Stop()
+
+	socket, err := thrift.NewTSocketTimeout(transport.Addr().String(), time.Second)
+
+	if err != nil || socket.Open() != nil {
+		t.Fatalf("fail to connect. [err:%v]", err)
+	}
+
+	defer socket.Close()
+
+	client := idl.NewPhp_Go_SvrClientFactory(socket, protocolFactory)
+
+	if _, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil {
+		t.Fatalf("fail to call GetUserByUserID. [err:%v]", err)
+	}
+
+	select {
+	case kvs = <-lines:
+	case <-time.After(time.Second):
+		t.Fatalf("access log must be written after response.")
+	}
+
+	caller, _ := kvs["caller"].(string)
+	latency, _ := kvs["latency_ms"].(float64)
+	requestBytes, _ := kvs["request_bytes"].(int64)
+	responseBytes, _ := kvs["response_bytes"].(int64)
+
+	if kvs["service"] != "Php_Go_Svr" || kvs["method"] != "GetUserByUserID" || kvs["code"] != "0" || !strings.HasPrefix(caller, "127.0.0.1:") || kvs["peer"] != caller {
+		t.Fatalf("invalid access log. [kvs:%v]", kvs)
+	}
+
+	if latency <= 0 || requestBytes <= 0 || responseBytes <= 0 {
+		t.Fatalf("latency and bytes must be logged. [kvs:%v]", kvs)
+	}
+
+	if _, ok := kvs["err"]; ok {
+		t.Fatalf("successful call must not log err. [kvs:%v]", kvs)
+	}
+}
+
+type timingHandler struct {
+	headerHandler
+	ctx context.Context
+}
+
+func (h timingHandler) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	timing.Start(h.ctx, "redis.get")()
+	return h.headerHandler.GetUserByUserID(req)
+}
+
+type testLogger struct {
+	log.Logger
+	lines []string
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestSlowLog(t *testing.T) {
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		return timingHandler{ctx: ctx}
+	}
+
+	logger := &testLogger{}
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(SlowLog(SlowLogOptions{
+		Logger:           logger,
+		Threshold:        time.Hour,
+		MethodThresholds: map[string]time.Duration{"GetUserByUserID": 0},
+		Debug:            true,
+	})))
+	resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+
+	if err != nil || !strings.HasPrefix(resp.Header.GetTiming(), "redis.get=") {
+		t.Fatalf("timing must be returned in debug mode. [resp:%v] [err:%v]", resp, err)
+	}
+
+	svr.SetUsers(&idl.SetUsersReq{})
+
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "GetUserByUserID") || !strings.Contains(logger.lines[0], "timing=redis.get=") {
+		t.Fatalf("only calls above method threshold must be logged. [actual:%v]", logger.lines)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var appID string
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		appID = auth.AppID(ctx)
+		return headerHandler{}
+	}
+
+	verifier := auth.NewVerifier(map[string]auth.App{"php_web": {Secret: "secret"}}, time.Minute)
+	optional := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Auth(AuthOptions{Verifier: verifier})))
+	required := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Auth(AuthOptions{Verifier: verifier, Required: true})))
+
+	if resp, err := optional.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.Header.Code != 0 || appID != "" {
+		t.Fatalf("unsigned request must be accepted when auth is optional. [resp:%v] [err:%v]", resp, err)
+	}
+
+	if resp, err := required.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.Header.Code != service.CodeUnauthenticated {
+		t.Fatalf("unsigned request must be rejected when auth is required. [resp:%v] [err:%v]", resp, err)
+	}
+
+	sign := func(req *idl.GetUserByIdReq, nonce string) {
+		payload, _ := signedPayload(req)
+		m := auth.Message{Method: "GetUserByUserID", Timestamp: time.Now().Unix(), Nonce: nonce, Trace: req.Trace, Payload: payload}
+		req.Auth = &idl.Auth{AppId: "php_web", Timestamp: m.Timestamp, Nonce: nonce, Signature: auth.Sign("secret", m)}
+	}
+
+	req := &idl.GetUserByIdReq{UserID: 1, Trace: map[string]string{"traceid": "0a0b0c0d5d8b0e2c3e5b1a2b00000001", "hintCode": "1"}}
+	sign(req, "n1")
+
+	if resp, err := required.GetUserByUserID(req); err != nil || resp.Header.Code != 0 || appID != "php_web" {
+		t.Fatalf("signed request must be accepted. [resp:%v] [err:%v] [appID:%v]", resp, err, appID)
+	}
+
+	if resp, err := required.GetUserByUserID(req); err != nil || resp.Header.Code != service.CodeUnauthenticated {
+		t.Fatalf("replayed request must be rejected. [resp:%v] [err:%v]", resp, err)
+	}
+
+	// 压测流量的签名不能用来发线上流量。
+	sign(req, "n2")
+	req.Trace["hintCode"] = "2"
+
+	if resp, err := optional.GetUserByUserID(req); err != nil || resp.Header.Code != service.CodeUnauthenticated {
+		t.Fatalf("request with tampered trace must be rejected. [resp:%v] [err:%v]", resp, err)
+	}
+
+	sign(req, "n3")
+	req.UserID = 2
+
+	if resp, err := optional.GetUserByUserID(req); err != nil || resp.Header.Code != service.CodeUnauthenticated {
+		t.Fatalf("tampered request must be rejected. [resp:%v] [err:%v]", resp, err)
+	}
+
+	// nonce 缓存满了的调用方被限流，而不是认证失败。
+	limited := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Auth(AuthOptions{
+		Verifier: auth.NewVerifier(map[string]auth.App{"php_web": {Secret: "secret", MaxQPS: 1}}, time.Second),
+	})))
+	req.UserID = 1
+
+	for i, code := range []int32{0, 0, service.CodeRateLimited} {
+		sign(req, fmt.Sprintf("limited%v", i))
+
+		if resp, err := limited.GetUserByUserID(req); err != nil || resp.Header.Code != code {
+			t.Fatalf("invalid response. [i:%v] [resp:%v] [err:%v]", i, resp, err)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	policy, err := authz.NewPolicy(map[string]authz.Members{
+		"reader": {CIDRs: []string{"127.0.0.0/8"}},
+		"writer": {AppIDs: []string{"user_sync_job"}},
+	}, map[string][]string{
+		"GetUserByUserID": {"reader", "writer"},
+		"SetUsers":        {"writer"},
+	}, "Php_Go_Svr")
+
+	if err != nil {
+		t.Fatalf("fail to create policy. [err:%v]", err)
+	}
+
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		return headerHandler{}
+	}
+
+	logger := &testLogger{}
+	chain := Chain(Authorize(AuthorizeOptions{Policy: policy, AuditLogger: logger}))
+	ctx := server.NewConnContext(context.Background(), &server.ConnInfo{RemoteAddr: "127.0.0.1:12345"})
+	svr := NewPhpGoSvr(ctx, "Php_Go_Svr", newHandler, chain)
+
+	if resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.Header.Code != 0 {
+		t.Fatalf("reader must be allowed to get user. [resp:%v] [err:%v]", resp, err)
+	}
+
+	if resp, err := svr.SetUsers(&idl.SetUsersReq{}); err != nil || resp.Header.Code != service.CodePermissionDenied {
+		t.Fatalf("reader must not be allowed to set users. [resp:%v] [err:%v]", resp, err)
+	}
+
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "SetUsers") || !strings.Contains(logger.lines[0], "roles=reader") {
+		t.Fatalf("denied call must be audited. [actual:%v]", logger.lines)
+	}
+
+	svr = NewPhpGoSvr(auth.NewContext(ctx, "user_sync_job"), "Php_Go_Svr", newHandler, chain)
+
+	// 允许调用时请求交给 testHandler，返回它的错误。
+	if resp, err := svr.SetUsers(&idl.SetUsersReq{}); err == nil || err.Error() != "set users error" {
+		t.Fatalf("writer must be allowed to set users. [resp:%v] [err:%v]", resp, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter, err := ratelimit.New([]ratelimit.Rule{{Method: "GetUserByUserID", Caller: "127.0.0.1", Rate: 1}})
+
+	if err != nil {
+		t.Fatalf("fail to create limiter. [err:%v]", err)
+	}
+
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		return headerHandler{}
+	}
+
+	ctx := server.NewConnContext(context.Background(), &server.ConnInfo{RemoteAddr: "127.0.0.1:12345"})
+	svr := NewPhpGoSvr(ctx, "Php_Go_Svr", newHandler, Chain(RateLimit(limiter)))
+
+	if resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.Header.Code != 0 {
+		t.Fatalf("first call must be allowed. [resp:%v] [err:%v]", resp, err)
+	}
+
+	resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+
+	if err != nil || resp.Header.Code != service.CodeRateLimited || resp.Header.GetRetryAfterMs() <= 0 || resp.Header.GetRetryAfterMs() > 1000 {
+		t.Fatalf("second call must be limited with retry after. [resp:%v] [err:%v]", resp, err)
+	}
+
+	// 其他连接上同一个 IP 的调用方共用一个桶。
+	ctx = server.NewConnContext(context.Background(), &server.ConnInfo{RemoteAddr: "127.0.0.1:23456"})
+	svr = NewPhpGoSvr(ctx, "Php_Go_Svr", newHandler, Chain(RateLimit(limiter)))
+
+	if resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.Header.Code != service.CodeRateLimited {
+		t.Fatalf("caller must be identified by ip. [resp:%v] [err:%v]", resp, err)
+	}
+}
+
+type blockingHandler struct {
+	headerHandler
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (h blockingHandler) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	h.started <- struct{}{}
+	<-h.unblock
+	return h.headerHandler.GetUserByUserID(req)
+}
+
+func TestOverload(t *testing.T) {
+	h := blockingHandler{started: make(chan struct{}), unblock: make(chan struct{})}
+	newHandler := func(ctx context.Context) idl.Php_Go_Svr {
+		return h
+	}
+
+	limiter := overload.New(overload.Options{InitialLimit: 2, ShadowRatio: overload.Ratio(0.5)})
+	svr := NewPhpGoSvr(context.Background(), "Php_Go_Svr", newHandler, Chain(Trace(), Overload(limiter)))
+	done := make(chan struct{})
+
+	go func() {
+		svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+		close(done)
+	}()
+
+	<-h.started
+
+	// 用了一半的上限之后压测流量被拒绝，线上流量还可以继续。
+	resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1, Trace: map[string]string{"hintCode": "1"}})
+
+	if err != nil || resp.Header.Code != service.CodeOverloaded {
+		t.Fatalf("shadow traffic must be shed first. [resp:%v] [err:%v]", resp, err)
+	}
+
+	go func() {
+		<-h.started
+	}()
+
+	go func() {
+		svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+	}()
+
+	for limiter.Status().Inflight != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.Header.Code != service.CodeOverloaded {
+		t.Fatalf("production traffic over limit must be shed. [resp:%v] [err:%v]", resp, err)
+	}
+
+	close(h.unblock)
+	<-done
+
+	for limiter.Status().Inflight != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"php-thrift-go-server/metrics"
+	"php-thrift-go-server/overload"
+	"php-thrift-go-server/service"
+)
+
+var rpcShed = metrics.Default.NewCounterVec("php_go_rpc_shed_total",
+	"Total RPC calls rejected by the adaptive concurrency limiter.", "service", "method", "traffic")
+
+// Overload 用自适应的并发上限保护服务，超过上限的调用直接返回 service.CodeOverloaded，不再排队等待。
+// Ultron 压测流量只能使用一部分上限，过载时先被拒绝。压测标记来自 trace，所以 Overload 要放在 Trace 后面。
+func Overload(limiter *overload.Limiter) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			shadow := trace.ContextHintCode(ctx).IsUltron()
+			release, ok := limiter.Acquire(shadow)
+
+			if !ok {
+				traffic := "prod"
+
+				if shadow {
+					traffic = "shadow"
+				}
+
+				rpcShed.With(call.Service, call.Method, traffic).Inc()
+				return errorResponse(call, service.CodeOverloaded, "server overloaded"), nil
+			}
+
+			// 业务代码 panic 时也要释放，否则并发数会一直被占用。
+			defer func() {
+				release(call.Elapsed())
+			}()
+
+			return next(ctx, call)
+		}
+	}
+}
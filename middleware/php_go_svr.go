@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+// PhpGoSvr 把 Middleware 包在 idl.Php_Go_Svr 的每个方法外面。
+// 每次调用都会用 newHandler 创建新的业务 handler，中间件放进 ctx 里的信息可以传给业务代码。
+type PhpGoSvr struct {
+	ctx        context.Context
+	service    string
+	newHandler func(ctx context.Context) idl.Php_Go_Svr
+	handler    Handler
+}
+
+// NewPhpGoSvr 创建 PhpGoSvr，ctx 是连接的 ctx，service 是服务名。
+func NewPhpGoSvr(ctx context.Context, service string, newHandler func(ctx context.Context) idl.Php_Go_Svr, middleware Middleware) *PhpGoSvr {
+	s := &PhpGoSvr{
+		ctx:        ctx,
+		service:    service,
+		newHandler: newHandler,
+	}
+
+	s.handler = s.invoke
+
+	if middleware != nil {
+		s.handler = middleware(s.handler)
+	}
+
+	return s
+}
+
+func (s *PhpGoSvr) invoke(ctx context.Context, call *Call) (interface{}, error) {
+	handler := s.newHandler(ctx)
+
+	switch args := call.Args.(type) {
+	case *idl.GetUserByIdReq:
+		return handler.GetUserByUserID(args)
+	case *idl.SetUsersReq:
+		return handler.SetUsers(args)
+	}
+
+	return nil, fmt.Errorf("unknown method %v", call.Method)
+}
+
+// responseHeader 返回响应中的 ResponseHeader，result 不是响应或者没有 header 时返回 nil。
+// handler 出错时 result 可能是 nil 的响应指针，不能直接调用 GetHeader。
+func responseHeader(result interface{}) *idl.ResponseHeader {
+	switch resp := result.(type) {
+	case *idl.GetUserByIdResp:
+		if resp != nil {
+			return resp.Header
+		}
+	case *idl.SetUsersResp:
+		if resp != nil {
+			return resp.Header
+		}
+	}
+
+	return nil
+}
+
+// errorResponse 返回 call 对应方法的响应，ResponseHeader 中是 code 和 msg，用于中间件直接拒绝请求。
+func errorResponse(call *Call, code int32, msg string) interface{} {
+	header := &idl.ResponseHeader{Code: code, Msg: msg}
+
+	switch call.Args.(type) {
+	case *idl.GetUserByIdReq:
+		return &idl.GetUserByIdResp{Header: header, User: &idl.UserInfo{}}
+	case *idl.SetUsersReq:
+		return &idl.SetUsersResp{Header: header, UserIDs: []int32{}}
+	}
+
+	return nil
+}
+
+func (s *PhpGoSvr) call(method string, args interface{}) (interface{}, error) {
+	return s.handler(s.ctx, &Call{
+		Service: s.service,
+		Method:  method,
+		Args:    args,
+		Start:   time.Now(),
+	})
+}
+
+func (s *PhpGoSvr) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	result, err := s.call("GetUserByUserID", req)
+	resp, _ := result.(*idl.GetUserByIdResp)
+	return resp, err
+}
+
+func (s *PhpGoSvr) SetUsers(req *idl.SetUsersReq) (*idl.SetUsersResp, error) {
+	result, err := s.call("SetUsers", req)
+	resp, _ := result.(*idl.SetUsersResp)
+	return resp, err
+}
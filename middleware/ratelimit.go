@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"php-thrift-go-server/ratelimit"
+	"php-thrift-go-server/service"
+)
+
+// RateLimit 按方法和调用方限流，被限流的调用返回 service.CodeRateLimited，ResponseHeader.RetryAfterMs 是建议的重试间隔。
+// 调用方身份优先使用 app id，所以 RateLimit 要放在 Auth 后面。
+func RateLimit(limiter *ratelimit.Limiter) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			retryAfter, ok := limiter.Allow(call.Method, callerID(ctx))
+
+			if ok {
+				return next(ctx, call)
+			}
+
+			result := errorResponse(call, service.CodeRateLimited, "rate limited")
+
+			if header := responseHeader(result); header != nil {
+				ms := int32((retryAfter + time.Millisecond - 1) / time.Millisecond)
+				header.RetryAfterMs = &ms
+			}
+
+			return result, nil
+		}
+	}
+}
+
+// callerID 返回限流使用的调用方身份：app id、TLS 客户端证书身份或者客户端 IP，同一个调用方的不同连接是同一个身份。
+func callerID(ctx context.Context) string {
+	id := identity(ctx)
+
+	switch {
+	case id.AppID != "":
+		return id.AppID
+	case id.TLSIdentity != "":
+		return id.TLSIdentity
+	case id.IP != nil:
+		return id.IP.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"php-thrift-go-server/util"
+)
+
+// Recover 恢复业务代码中的 panic 并记录堆栈，把 panic 转成错误返回。
+// 生成的 processor 会把错误作为 INTERNAL_ERROR 异常回复给客户端，连接可以继续使用。
+// Recover 应该放在 Metrics 和 AccessLog 后面，panic 的调用也要被统计和记录，
+// 同时放在其他中间件前面，这样其他中间件里的 panic 也能被恢复。
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (result interface{}, err error) {
+			defer func() {
+				if e := recover(); e != nil {
+					log.Errorf("Service||panic in %v||%v||caller=%v||req=%v||err=%v||stack=%s", call.Method, trace.ContextString(ctx), Caller(ctx), util.JsonString(call.Args), e, debug.Stack())
+					result, err = nil, fmt.Errorf("panic: %v", e)
+				}
+			}()
+
+			return next(ctx, call)
+		}
+	}
+}
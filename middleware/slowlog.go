@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"php-thrift-go-server/server"
+	"php-thrift-go-server/timing"
+)
+
+// SlowLogOptions 是慢日志的配置。
+type SlowLogOptions struct {
+	Logger           log.Logger               // 慢日志写到单独的文件，不和业务日志混在一起。
+	Threshold        time.Duration            // 耗时超过阈值的调用才输出，0 表示输出所有调用。
+	MethodThresholds map[string]time.Duration // 按方法名覆盖 Threshold。
+	Debug            bool                     // 在响应的 ResponseHeader.Timing 中返回耗时分解。
+}
+
+func (opts *SlowLogOptions) threshold(method string) time.Duration {
+	if threshold, ok := opts.MethodThresholds[method]; ok {
+		return threshold
+	}
+
+	return opts.Threshold
+}
+
+// SlowLog 记录每次调用各个阶段的耗时，耗时超过方法的阈值时把耗时分解写到慢日志。
+// 阶段包括解码请求（decode）、每个 Redis 命令（redis.get、redis.set）、JSON 序列化（json_marshal、json_unmarshal）
+// 和编码并写响应（encode），业务代码通过 timing.Start(ctx, name) 记录自己的阶段。
+// decode 和 encode 只有在 Server 处理的连接上才有，这时的耗时从读到请求的第一个字节开始算。
+// Debug 模式下返回给调用方的耗时分解不包括 encode。
+func SlowLog(opts SlowLogOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			ctx, recorder := timing.NewContext(ctx)
+			start := server.RequestStart(ctx)
+
+			if !start.IsZero() {
+				recorder.Add("decode", call.Start.Sub(start))
+			} else {
+				start = call.Start
+			}
+
+			result, err := next(ctx, call)
+			handled := time.Now()
+
+			if header := responseHeader(result); header != nil && opts.Debug {
+				breakdown := recorder.String()
+				header.Timing = &breakdown
+			}
+
+			write := func() {
+				latency := time.Since(start)
+				threshold := opts.threshold(call.Method)
+
+				if latency < threshold {
+					return
+				}
+
+				opts.Logger.Infof("SlowLog||%v||%v||caller=%v||code=%v||latency_ms=%.3f||threshold_ms=%v||timing=%v",
+					call.Method, trace.ContextString(ctx), Caller(ctx), ResponseCode(result, err),
+					float64(latency.Nanoseconds())/1e6, threshold.Nanoseconds()/1e6, recorder)
+			}
+
+			if !server.AfterResponse(ctx, func(int64, int64) {
+				recorder.Add("encode", time.Since(handled))
+				write()
+			}) {
+				write()
+			}
+
+			return result, err
+		}
+	}
+}
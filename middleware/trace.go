@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"reflect"
+
+	"git.xiaojukeji.com/soda-framework/go-trace"
+)
+
+// Trace 使用请求中 PHP 传过来的 trace 信息创建调用的 ctx，请求没有带 trace 时生成新的 traceid。
+// 之后的中间件和业务代码都可以用 trace.ContextString(ctx) 打印日志，
+// traceid 会写到响应的 ResponseHeader 里，方便把 PHP 和 Go 的日志串起来。
+// Trace 应该放在 Chain 的第一个，这样其他中间件的日志里也有 trace 信息。
+func Trace() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, call *Call) (interface{}, error) {
+			var tr trace.Trace
+
+			// 请求可能是 nil 指针，生成的 GetTrace 不能在 nil 上调用，Trace 在 Recover 外面，panic 不会被恢复。
+			if req, ok := call.Args.(interface {
+				GetTrace() map[string]string
+			}); ok && !isNilPointer(call.Args) {
+				tr = trace.Trace(req.GetTrace())
+			}
+
+			ctx = trace.NewContext(ctx, tr)
+			result, err := next(ctx, call)
+
+			if header := responseHeader(result); header != nil {
+				traceid := string(trace.FromContext(ctx).Traceid())
+				header.Traceid = &traceid
+			}
+
+			return result, err
+		}
+	}
+}
+
+func isNilPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
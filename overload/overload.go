@@ -0,0 +1,146 @@
+// Package overload 实现自适应的并发限制：按 AIMD 根据请求耗时调整同时处理的请求数上限，
+// 超过上限的请求直接拒绝，不在 server 里排队。Ultron 压测流量只能使用上限的一部分，过载时先被拒绝。
+package overload
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Options 是 Limiter 的参数，为 0 的字段使用默认值，ShadowRatio 为 nil 时使用默认值。
+type Options struct {
+	InitialLimit  int           // 初始的并发上限，默认 100。
+	MinLimit      int           // 并发上限不会低于这个值，默认 1。
+	MaxLimit      int           // 并发上限不会高于这个值，默认 1000。
+	LatencyTarget time.Duration // 耗时超过这个值时认为过载，按 Backoff 降低上限，默认 100ms。
+	Backoff       float64       // 过载时上限乘以这个值，取值 (0, 1)，默认 0.9。
+	ShadowRatio   *float64      // Ultron 压测流量最多使用上限的这个比例，取值 [0, 1]，0 表示拒绝所有压测流量，为 nil 时默认 0.5。
+}
+
+// Ratio 返回 ShadowRatio 使用的指针。
+func Ratio(ratio float64) *float64 {
+	return &ratio
+}
+
+func (opts *Options) setDefaults() {
+	if opts.InitialLimit <= 0 {
+		opts.InitialLimit = 100
+	}
+
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 1
+	}
+
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = 1000
+	}
+
+	if opts.LatencyTarget <= 0 {
+		opts.LatencyTarget = 100 * time.Millisecond
+	}
+
+	if opts.Backoff <= 0 || opts.Backoff >= 1 {
+		opts.Backoff = 0.9
+	}
+
+	// 只有没有设置时才使用默认值，显式设置的 0 表示不给压测流量留并发名额。
+	if opts.ShadowRatio == nil || *opts.ShadowRatio < 0 || *opts.ShadowRatio > 1 {
+		opts.ShadowRatio = Ratio(0.5)
+	}
+}
+
+// Limiter 是自适应的并发限制，可以在多个 goroutine 中使用。
+type Limiter struct {
+	mu           sync.Mutex
+	opts         Options
+	limit        float64
+	inflight     int
+	lastDecrease time.Time
+	shed         int64
+	shadowShed   int64
+	now          func() time.Time
+}
+
+// New 创建 Limiter。
+func New(opts Options) *Limiter {
+	opts.setDefaults()
+
+	return &Limiter{
+		opts:  opts,
+		limit: math.Max(float64(opts.MinLimit), math.Min(float64(opts.MaxLimit), float64(opts.InitialLimit))),
+		now:   time.Now,
+	}
+}
+
+// Acquire 在处理请求之前调用，shadow 表示 Ultron 压测流量。
+// 返回 false 时请求应该直接拒绝；返回 true 时处理完之后必须调用一次 release，参数是请求的耗时。
+func (l *Limiter) Acquire(shadow bool) (release func(latency time.Duration), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.limit
+
+	if shadow {
+		limit *= *l.opts.ShadowRatio
+	}
+
+	if float64(l.inflight) >= limit {
+		if shadow {
+			l.shadowShed++
+		} else {
+			l.shed++
+		}
+
+		return nil, false
+	}
+
+	l.inflight++
+	return l.release, true
+}
+
+// release 根据请求耗时调整上限：超过 LatencyTarget 时乘以 Backoff，否则在上限被用满一半以上时加 1/limit，
+// 也就是大约每处理 limit 个请求加 1。一个 LatencyTarget 内最多降低一次，避免一批慢请求把上限降到最低。
+func (l *Limiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	now := l.now()
+
+	if latency > l.opts.LatencyTarget {
+		if now.Sub(l.lastDecrease) >= l.opts.LatencyTarget {
+			l.limit = math.Max(float64(l.opts.MinLimit), l.limit*l.opts.Backoff)
+			l.lastDecrease = now
+		}
+
+		return
+	}
+
+	if float64(l.inflight+1)*2 >= l.limit {
+		l.limit = math.Min(float64(l.opts.MaxLimit), l.limit+1/l.limit)
+	}
+}
+
+// Status 是 Limiter 当前的状态，在 admin 接口上展示。
+type Status struct {
+	Limit       float64 `json:"limit"`
+	ShadowLimit float64 `json:"shadow_limit"`
+	Inflight    int     `json:"inflight"`
+	Shed        int64   `json:"shed"`
+	ShadowShed  int64   `json:"shadow_shed"`
+}
+
+// Status 返回当前的并发上限、正在处理的请求数和拒绝的请求数。
+func (l *Limiter) Status() Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return Status{
+		Limit:       l.limit,
+		ShadowLimit: l.limit * *l.opts.ShadowRatio,
+		Inflight:    l.inflight,
+		Shed:        l.shed,
+		ShadowShed:  l.shadowShed,
+	}
+}
@@ -0,0 +1,83 @@
+package overload
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Options{InitialLimit: 4, MinLimit: 2, MaxLimit: 5, LatencyTarget: 10 * time.Millisecond, Backoff: 0.5, ShadowRatio: Ratio(0.5)})
+	now := time.Unix(1500000000, 0)
+	l.now = func() time.Time {
+		return now
+	}
+
+	// 压测流量只能用一半的上限，过载时先被拒绝。
+	var releases []func(time.Duration)
+
+	for i := 0; i < 3; i++ {
+		release, ok := l.Acquire(true)
+
+		if i < 2 && !ok || i == 2 && ok {
+			t.Fatalf("shadow traffic must be limited to half. [i:%v] [ok:%v]", i, ok)
+		}
+
+		if ok {
+			releases = append(releases, release)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		release, ok := l.Acquire(false)
+
+		if i < 2 && !ok || i == 2 && ok {
+			t.Fatalf("production traffic must be limited. [i:%v] [ok:%v]", i, ok)
+		}
+
+		if ok {
+			releases = append(releases, release)
+		}
+	}
+
+	if s := l.Status(); s.Inflight != 4 || s.Shed != 1 || s.ShadowShed != 1 {
+		t.Fatalf("invalid status. [actual:%+v]", s)
+	}
+
+	// 连续的慢请求在一个 LatencyTarget 内只降低一次。
+	releases[0](time.Second)
+	releases[1](time.Second)
+
+	if s := l.Status(); s.Limit != 2 || s.Inflight != 2 {
+		t.Fatalf("limit must be decreased once. [actual:%+v]", s)
+	}
+
+	now = now.Add(time.Second)
+	releases[2](time.Second)
+
+	if s := l.Status(); s.Limit != 2 {
+		t.Fatalf("limit must not be lower than min limit. [actual:%+v]", s)
+	}
+
+	releases[3](time.Millisecond)
+
+	if s := l.Status(); s.Limit != 2.5 || s.Inflight != 0 {
+		t.Fatalf("limit must be increased by fast request. [actual:%+v]", s)
+	}
+}
+
+func TestLimiterNoShadow(t *testing.T) {
+	// 明确配置为 0 时拒绝所有压测流量，不能被当作没有配置改成默认值。
+	l := New(Options{InitialLimit: 4, ShadowRatio: Ratio(0)})
+
+	if _, ok := l.Acquire(true); ok {
+		t.Fatalf("shadow traffic must be rejected with zero ratio.")
+	}
+
+	if _, ok := l.Acquire(false); !ok {
+		t.Fatalf("production traffic must be allowed. [status:%+v]", l.Status())
+	}
+
+	if l := New(Options{InitialLimit: 4}); *l.opts.ShadowRatio != 0.5 {
+		t.Fatalf("unset ratio must default to half. [actual:%v]", *l.opts.ShadowRatio)
+	}
+}
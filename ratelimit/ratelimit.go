@@ -0,0 +1,220 @@
+// Package ratelimit 实现按方法和调用方限流的令牌桶，规则可以在运行时替换。
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Any 在 Rule 的 Method 和 Caller 中匹配任意值。
+const Any = "*"
+
+// pruneInterval 是清理空闲令牌桶的间隔，已经补满的桶和新建的桶没有区别，可以删掉。
+const pruneInterval = time.Minute
+
+// Rule 是一条限流规则，一个请求匹配多条规则时每条规则的桶里都要有令牌才能通过。
+type Rule struct {
+	Method string  `json:"method"` // 方法名，* 匹配所有方法。
+	Caller string  `json:"caller"` // 调用方身份，* 匹配所有调用方。
+	Rate   float64 `json:"rate"`   // 每秒补充的令牌数。
+	Burst  int     `json:"burst"`  // 桶的容量，为 0 时使用 Rate 向上取整。
+	Shared bool    `json:"shared"` // 匹配的请求共用一个桶，默认每个方法和调用方的组合一个桶。
+}
+
+func (r *Rule) match(method, caller string) bool {
+	return (r.Method == Any || r.Method == method) && (r.Caller == Any || r.Caller == caller)
+}
+
+type bucketKey struct {
+	rule   int
+	method string
+	caller string
+}
+
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	allowed  int64
+	rejected int64
+}
+
+// Limiter 是一组限流规则和它们的令牌桶，可以在多个 goroutine 中使用。
+type Limiter struct {
+	mu        sync.Mutex
+	rules     []Rule
+	buckets   map[bucketKey]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// New 创建 Limiter，规则不合法时返回错误。
+func New(rules []Rule) (*Limiter, error) {
+	l := &Limiter{now: time.Now}
+
+	if err := l.Update(rules); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Update 替换所有规则，所有的桶重新开始计算。规则不合法时返回错误，原来的规则继续生效。
+func (l *Limiter) Update(rules []Rule) error {
+	rules = append([]Rule(nil), rules...)
+
+	for i := range rules {
+		rule := &rules[i]
+
+		if rule.Method == "" || rule.Caller == "" {
+			return fmt.Errorf("rule %v: method and caller must not be empty, use %q to match any", i, Any)
+		}
+
+		if rule.Rate <= 0 || rule.Burst < 0 {
+			return fmt.Errorf("rule %v: rate must be positive and burst must not be negative", i)
+		}
+
+		if rule.Burst == 0 {
+			rule.Burst = int(math.Ceil(rule.Rate))
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = rules
+	l.buckets = make(map[bucketKey]*bucket)
+	return nil
+}
+
+// Allow 判断 caller 现在能否调用 method，不能调用时返回的 retryAfter 是桶里有令牌的等待时间。
+func (l *Limiter) Allow(method, caller string) (retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+	var matched []*bucket
+
+	for i := range l.rules {
+		rule := &l.rules[i]
+
+		if !rule.match(method, caller) {
+			continue
+		}
+
+		key := bucketKey{rule: i}
+
+		if !rule.Shared {
+			key.method, key.caller = method, caller
+		}
+
+		b := l.buckets[key]
+
+		if b == nil {
+			b = &bucket{tokens: float64(rule.Burst), last: now}
+			l.buckets[key] = b
+		}
+
+		b.refill(rule, now)
+
+		if b.tokens < 1 {
+			b.rejected++
+
+			if wait := time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second)); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+
+		matched = append(matched, b)
+	}
+
+	if retryAfter > 0 {
+		return retryAfter, false
+	}
+
+	for _, b := range matched {
+		b.tokens--
+		b.allowed++
+	}
+
+	return 0, true
+}
+
+func (b *bucket) refill(rule *Rule, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed.Seconds()*rule.Rate)
+		b.last = now
+	}
+}
+
+// prune 删除已经补满的桶，避免按调用方创建的桶无限增长。
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+
+	l.lastPrune = now
+
+	for key, b := range l.buckets {
+		rule := &l.rules[key.rule]
+
+		if b.refill(rule, now); b.tokens >= float64(rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// BucketStatus 是一个令牌桶的状态，共用的桶 Method 和 Caller 为空。
+type BucketStatus struct {
+	Rule     int     `json:"rule"`
+	Method   string  `json:"method"`
+	Caller   string  `json:"caller"`
+	Tokens   float64 `json:"tokens"`
+	Allowed  int64   `json:"allowed"`
+	Rejected int64   `json:"rejected"`
+}
+
+// Status 是 Limiter 当前的规则和令牌桶，在 admin 接口上展示。
+type Status struct {
+	Rules   []Rule         `json:"rules"`
+	Buckets []BucketStatus `json:"buckets"`
+}
+
+// Status 返回当前的规则和所有令牌桶的状态，令牌数按现在的时间补充之后计算。
+func (l *Limiter) Status() Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	status := Status{Rules: append([]Rule(nil), l.rules...), Buckets: make([]BucketStatus, 0, len(l.buckets))}
+
+	for key, b := range l.buckets {
+		b.refill(&l.rules[key.rule], now)
+		status.Buckets = append(status.Buckets, BucketStatus{
+			Rule:     key.rule,
+			Method:   key.method,
+			Caller:   key.caller,
+			Tokens:   b.tokens,
+			Allowed:  b.allowed,
+			Rejected: b.rejected,
+		})
+	}
+
+	sort.Slice(status.Buckets, func(i, j int) bool {
+		a, b := status.Buckets[i], status.Buckets[j]
+
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+
+		return a.Caller < b.Caller
+	})
+
+	return status
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l, err := New([]Rule{
+		{Method: "SetUsers", Caller: Any, Rate: 1, Burst: 2},
+		{Method: Any, Caller: Any, Rate: 3, Shared: true},
+	})
+
+	if err != nil {
+		t.Fatalf("fail to create limiter. [err:%v]", err)
+	}
+
+	now := time.Unix(1500000000, 0)
+	l.now = func() time.Time {
+		return now
+	}
+
+	allow := func(method, caller string) (time.Duration, bool) {
+		return l.Allow(method, caller)
+	}
+
+	// 每个调用方单独一个 SetUsers 的桶，所有请求共用一个容量为 3 的桶。
+	if _, ok := allow("SetUsers", "cron"); !ok {
+		t.Fatalf("first call must be allowed.")
+	}
+
+	if _, ok := allow("SetUsers", "cron"); !ok {
+		t.Fatalf("burst must be allowed.")
+	}
+
+	if retryAfter, ok := allow("SetUsers", "cron"); ok || retryAfter != time.Second {
+		t.Fatalf("call over burst must be rejected. [retryAfter:%v] [ok:%v]", retryAfter, ok)
+	}
+
+	if _, ok := allow("SetUsers", "web"); !ok {
+		t.Fatalf("other caller must have its own bucket.")
+	}
+
+	if retryAfter, ok := allow("GetUserByUserID", "web"); ok || retryAfter != time.Second/3 {
+		t.Fatalf("shared bucket must be exhausted. [retryAfter:%v] [ok:%v]", retryAfter, ok)
+	}
+
+	now = now.Add(time.Second)
+
+	if _, ok := allow("SetUsers", "cron"); !ok {
+		t.Fatalf("tokens must be refilled.")
+	}
+
+	status := l.Status()
+
+	if len(status.Rules) != 2 || status.Rules[1].Burst != 3 || len(status.Buckets) != 3 {
+		t.Fatalf("invalid status. [actual:%+v]", status)
+	}
+
+	if b := status.Buckets[0]; b.Caller != "cron" || b.Allowed != 3 || b.Rejected != 1 {
+		t.Fatalf("invalid bucket status. [actual:%+v]", b)
+	}
+
+	if err := l.Update([]Rule{{Method: "SetUsers", Caller: Any}}); err == nil {
+		t.Fatalf("rule without rate must be rejected.")
+	}
+
+	if len(l.Status().Rules) != 2 {
+		t.Fatalf("invalid update must not replace rules.")
+	}
+
+	now = now.Add(pruneInterval)
+	allow("GetUserByUserID", "web")
+
+	if buckets := l.Status().Buckets; len(buckets) != 1 {
+		t.Fatalf("full buckets must be pruned. [actual:%+v]", buckets)
+	}
+}
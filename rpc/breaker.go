@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+//熔断器打开时不访问 Redis，直接返回这个错误
+var ErrCircuitOpen = errors.New("redis circuit breaker is open")
+
+//熔断器的状态
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota //正常访问 Redis，统计连续失败次数
+	StateOpen                         //直接返回 ErrCircuitOpen，OpenTimeout 之后进入半开
+	StateHalfOpen                     //只放行少量探测请求，全部成功之后关闭，任意一个失败重新打开
+)
+
+var breakerStates = []BreakerState{StateClosed, StateOpen, StateHalfOpen}
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+//BreakerOptions 是熔断器的参数，为 0 的字段使用默认值
+type BreakerOptions struct {
+	FailureThreshold int           //连续失败多少次之后打开，默认 5
+	OpenTimeout      time.Duration //打开多久之后进入半开，默认 5s
+	HalfOpenProbes   int           //半开时同时放行的探测请求数，这么多次连续成功之后关闭，默认 1
+}
+
+func (opts *BreakerOptions) setDefaults() {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 5 * time.Second
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+}
+
+//熔断器，每个 keyspace 一个，影子 Redis 故障时不影响线上流量
+type breaker struct {
+	mu          sync.Mutex
+	name        string
+	opts        BreakerOptions
+	state       BreakerState
+	failures    int //关闭时的连续失败次数
+	probes      int //半开时正在执行的探测请求数
+	successes   int //半开时成功的探测请求数
+	openedAt    time.Time
+	generation  uint64 //每次状态变化加一，之前的状态放行的请求的结果不影响当前状态
+	rejected    int64
+	transitions map[BreakerState]int64
+	now         func() time.Time
+}
+
+func newBreaker(name string, opts BreakerOptions) *breaker {
+	opts.setDefaults()
+	return &breaker{name: name, opts: opts, transitions: make(map[BreakerState]int64), now: time.Now}
+}
+
+//判断能否访问 Redis，返回 true 时必须用返回的 generation 调用一次 done 或者 release 报告结果
+func (b *breaker) allow() (generation uint64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.opts.OpenTimeout {
+		b.setState(StateHalfOpen)
+	}
+	switch b.state {
+	case StateOpen:
+		b.rejected++
+		return b.generation, false
+	case StateHalfOpen:
+		if b.probes+b.successes >= b.opts.HalfOpenProbes {
+			b.rejected++
+			return b.generation, false
+		}
+		b.probes++
+	}
+	return b.generation, true
+}
+
+//报告 Redis 命令的结果，failed 表示 Redis 不可用。
+//状态变化之前放行的请求不影响状态，比如关闭时放行、半开之后才返回的请求不能算作探测请求。
+func (b *breaker) done(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.opts.FailureThreshold {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		b.probes--
+		if failed {
+			b.setState(StateOpen)
+		} else if b.successes++; b.successes >= b.opts.HalfOpenProbes {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+//调用方超时或者取消，没有等到 Redis 的结果，既不算成功也不算失败，半开时让出探测名额
+func (b *breaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == StateHalfOpen {
+		b.probes--
+	}
+}
+
+//调用方持有 b.mu
+func (b *breaker) setState(state BreakerState) {
+	log.Warnf("Rpc||breaker||state changed||keyspace=%v||from=%v||to=%v||failures=%v", b.name, b.state, state, b.failures)
+	b.state = state
+	b.generation++
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if state == StateOpen {
+		b.openedAt = b.now()
+	}
+	b.transitions[state]++
+}
+
+//BreakerStatus 是一个 keyspace 的熔断器状态
+type BreakerStatus struct {
+	State       string           `json:"state"`
+	Failures    int              `json:"failures"`
+	Rejected    int64            `json:"rejected"`
+	Transitions map[string]int64 `json:"transitions"` //进入每个状态的次数
+}
+
+func (b *breaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state.String(), Failures: b.failures, Rejected: b.rejected, Transitions: make(map[string]int64)}
+	for _, state := range breakerStates {
+		status.Transitions[state.String()] = b.transitions[state]
+	}
+	return status
+}
+
+//EnableBreaker 给线上和影子 Redis 各加一个熔断器，在处理请求之前调用，不调用时不熔断
+func EnableBreaker(opts BreakerOptions) {
+	for _, ks := range keyspaces {
+		ks.breaker = newBreaker(ks.name, opts)
+	}
+}
+
+//BreakerStatuses 返回每个 keyspace 的熔断器状态，key 是 prod 和 shadow，没有开启熔断时返回 nil
+func BreakerStatuses() map[string]BreakerStatus {
+	statuses := make(map[string]BreakerStatus)
+	for _, ks := range keyspaces {
+		if ks.breaker == nil {
+			return nil
+		}
+		statuses[ks.name] = ks.breaker.status()
+	}
+	return statuses
+}
@@ -0,0 +1,218 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"github.com/go-redis/redis"
+	"php-thrift-go-server/client"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker("prod", BreakerOptions{FailureThreshold: 2, OpenTimeout: time.Second, HalfOpenProbes: 2})
+	now := time.Unix(1500000000, 0)
+	b.now = func() time.Time {
+		return now
+	}
+
+	allowed := func() bool {
+		_, ok := b.allow()
+		return ok
+	}
+
+	// 成功会清零连续失败次数。
+	for _, failed := range []bool{true, false, true} {
+		generation, ok := b.allow()
+
+		if !ok {
+			t.Fatalf("closed breaker must allow calls.")
+		}
+
+		b.done(generation, failed)
+	}
+
+	generation, _ := b.allow()
+	b.done(generation, true)
+
+	if b.currentState() != StateOpen {
+		t.Fatalf("breaker must be opened after consecutive failures. [state:%v]", b.currentState())
+	}
+
+	if allowed() {
+		t.Fatalf("open breaker must reject calls.")
+	}
+
+	// OpenTimeout 之后放行 HalfOpenProbes 个探测请求，任意一个失败重新打开。
+	now = now.Add(time.Second)
+
+	if !allowed() || !allowed() || allowed() {
+		t.Fatalf("half open breaker must allow exactly 2 probes.")
+	}
+
+	// 两个探测请求都是半开之后放行的。
+	generation = b.generation
+	b.done(generation, false)
+	b.done(generation, true)
+
+	if b.currentState() != StateOpen {
+		t.Fatalf("failed probe must open breaker. [state:%v]", b.currentState())
+	}
+
+	now = now.Add(time.Second)
+
+	for i := 0; i < 2; i++ {
+		generation, ok := b.allow()
+
+		if !ok {
+			t.Fatalf("half open breaker must allow probes.")
+		}
+
+		b.done(generation, false)
+	}
+
+	if b.currentState() != StateClosed || !allowed() {
+		t.Fatalf("successful probes must close breaker. [state:%v]", b.currentState())
+	}
+
+	status := b.status()
+
+	if status.State != "closed" || status.Rejected != 2 || status.Transitions["open"] != 2 || status.Transitions["half_open"] != 2 {
+		t.Fatalf("invalid status. [actual:%+v]", status)
+	}
+}
+
+// 关闭时放行的请求在半开之后才返回，不能算作探测请求，也不能让 probes 变成负数。
+func TestBreakerStaleResult(t *testing.T) {
+	b := newBreaker("prod", BreakerOptions{FailureThreshold: 1, OpenTimeout: time.Second, HalfOpenProbes: 1})
+	now := time.Unix(1500000000, 0)
+	b.now = func() time.Time {
+		return now
+	}
+
+	slow, _ := b.allow()
+	failed, _ := b.allow()
+	b.done(failed, true)
+	now = now.Add(time.Second)
+	probe, ok := b.allow()
+
+	if !ok || b.currentState() != StateHalfOpen {
+		t.Fatalf("half open breaker must allow a probe. [state:%v]", b.currentState())
+	}
+
+	b.done(slow, false)
+	b.release(slow)
+
+	if b.currentState() != StateHalfOpen || b.probes != 1 || b.successes != 0 {
+		t.Fatalf("stale result must be ignored. [state:%v] [probes:%v] [successes:%v]", b.currentState(), b.probes, b.successes)
+	}
+
+	if _, ok := b.allow(); ok {
+		t.Fatalf("probe slot must still be taken.")
+	}
+
+	b.done(probe, false)
+
+	if b.currentState() != StateClosed {
+		t.Fatalf("successful probe must close breaker. [state:%v]", b.currentState())
+	}
+
+	// 半开时放行的探测请求在关闭之后才失败，也不影响关闭之后的连续失败次数。
+	b.done(probe, true)
+
+	if b.currentState() != StateClosed || b.failures != 0 {
+		t.Fatalf("stale failure must be ignored. [state:%v] [failures:%v]", b.currentState(), b.failures)
+	}
+}
+
+func TestBreakerFailFast(t *testing.T) {
+	b := newBreaker("prod", BreakerOptions{})
+	b.setState(StateOpen)
+	prodKeyspace.breaker = b
+	defer func() {
+		prodKeyspace.breaker = nil
+	}()
+
+	prodCtx := trace.NewContext(context.Background(), trace.Trace{"hintCode": "2"})
+	before := KeyspaceStats()
+
+	// 熔断器打开时不访问 Redis，client.RedisClient 没有初始化也不会被调用。
+	if _, err := RedisGet(prodCtx, "1"); err != ErrCircuitOpen {
+		t.Fatalf("open breaker must fail fast. [err:%v]", err)
+	}
+
+	if err := RedisSet(prodCtx, "1", "user"); err != ErrCircuitOpen {
+		t.Fatalf("open breaker must fail fast. [err:%v]", err)
+	}
+
+	if after := KeyspaceStats(); after["prod"].Errors != before["prod"].Errors {
+		t.Fatalf("rejected calls must not be counted as redis errors. [before:%+v] [after:%+v]", before, after)
+	}
+
+	if statuses := BreakerStatuses(); statuses != nil {
+		t.Fatalf("statuses must be nil unless all keyspaces have breakers. [actual:%+v]", statuses)
+	}
+}
+
+func TestBreakerIgnoresCallerTimeout(t *testing.T) {
+	// 只接受连接不返回结果的 Redis，每个命令都等到调用方超时。
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	client.ShadowRedisClient = redis.NewClient(&redis.Options{Addr: ln.Addr().String(), ReadTimeout: time.Minute})
+	b := newBreaker("shadow", BreakerOptions{FailureThreshold: 2, HalfOpenProbes: 1})
+	shadowKeyspace.breaker = b
+	defer func() {
+		client.ShadowRedisClient.Close()
+		client.ShadowRedisClient = nil
+		shadowKeyspace.breaker = nil
+	}()
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(trace.NewContext(context.Background(), trace.Trace{"hintCode": "1"}), 10*time.Millisecond)
+		_, err := RedisGet(ctx, "1")
+		cancel()
+
+		if err != ErrDeadlineExceeded {
+			t.Fatalf("hanging redis must exceed caller deadline. [err:%v]", err)
+		}
+	}
+
+	if b.currentState() != StateClosed {
+		t.Fatalf("caller timeouts must not open breaker. [state:%v]", b.currentState())
+	}
+
+	// 半开时超时的探测请求让出名额，不重新打开也不关闭。
+	b.setState(StateHalfOpen)
+
+	generation, ok := b.allow()
+
+	if !ok {
+		t.Fatalf("half open breaker must allow a probe.")
+	}
+
+	b.release(generation)
+
+	if _, ok := b.allow(); b.currentState() != StateHalfOpen || !ok {
+		t.Fatalf("released probe must free its slot. [state:%v]", b.currentState())
+	}
+}
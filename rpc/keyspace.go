@@ -0,0 +1,127 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"github.com/go-redis/redis"
+	"php-thrift-go-server/client"
+	"php-thrift-go-server/timing"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var errShadowNotInitialized = errors.New("shadow redis is not initialized")
+
+//Redis 的 keyspace，Ultron 压测流量读写影子 Redis 中带前缀的 key，线上流量读写线上 Redis，
+//两者的调用次数分开统计。压测流量不会回退到线上 Redis，线上流量也不能访问带压测前缀的 key。
+type keyspace struct {
+	name    string
+	shadow  bool
+	stats   Stats
+	breaker *breaker //没有开启熔断时为 nil
+}
+
+var (
+	prodKeyspace   = &keyspace{name: "prod"}
+	shadowKeyspace = &keyspace{name: "shadow", shadow: true}
+	keyspaces      = []*keyspace{prodKeyspace, shadowKeyspace}
+)
+
+//Stats 是一个 keyspace 的 Redis 调用次数
+type Stats struct {
+	Calls    int64
+	Errors   int64 //Redis 返回的错误，不包括 key 不存在
+	Timeouts int64 //调用方超时，包括超时之后没有执行的命令
+}
+
+func (s *Stats) load() Stats {
+	return Stats{
+		Calls:    atomic.LoadInt64(&s.Calls),
+		Errors:   atomic.LoadInt64(&s.Errors),
+		Timeouts: atomic.LoadInt64(&s.Timeouts),
+	}
+}
+
+//KeyspaceStats 返回线上和压测流量各自的 Redis 调用次数，key 是 prod 和 shadow
+func KeyspaceStats() map[string]Stats {
+	return map[string]Stats{
+		prodKeyspace.name:   prodKeyspace.stats.load(),
+		shadowKeyspace.name: shadowKeyspace.stats.load(),
+	}
+}
+
+//根据 trace 中的 hintCode 选择 keyspace
+func keyspaceOf(ctx context.Context) *keyspace {
+	if trace.ContextHintCode(ctx).IsUltron() {
+		return shadowKeyspace
+	}
+	return prodKeyspace
+}
+
+//返回 key 在 keyspace 中实际使用的 key
+func (ks *keyspace) key(key string) (string, error) {
+	if ks.shadow {
+		return client.ShadowKeyPrefix + key, nil
+	}
+	if client.ShadowKeyPrefix != "" && strings.HasPrefix(key, client.ShadowKeyPrefix) {
+		return key, errors.New("production request must not access shadow key")
+	}
+	return key, nil
+}
+
+func (ks *keyspace) client() (*redis.Client, error) {
+	if ks.shadow {
+		if client.ShadowRedisClient == nil {
+			return nil, errShadowNotInitialized
+		}
+		return client.ShadowRedisClient, nil
+	}
+	return &client.RedisClient, nil
+}
+
+//执行 Redis 命令并统计调用次数，耗时记录到慢日志的耗时分解中。
+//开启熔断时命令要经过熔断器，调用之前就已经超时的命令不经过熔断器，
+//等待结果时调用方超时或者取消不能说明 Redis 不可用，不算作失败。
+func (ks *keyspace) process(ctx context.Context, cmd redis.Cmder) error {
+	atomic.AddInt64(&ks.stats.Calls, 1)
+	defer timing.Start(ctx, "redis."+cmd.Name())()
+	c, err := ks.client()
+	b := ks.breaker
+	if err != nil || expired(ctx) {
+		b = nil
+	}
+	var generation uint64
+	if b != nil {
+		var ok bool
+		if generation, ok = b.allow(); !ok {
+			err = ErrCircuitOpen
+		}
+	}
+	if err == nil {
+		start := time.Now()
+		err = process(ctx, c, cmd)
+		if err != ErrDeadlineExceeded {
+			redisDuration.With(ks.name, cmd.Name()).Observe(time.Since(start).Seconds())
+		}
+		if b != nil {
+			if callerGaveUp(err) {
+				b.release(generation)
+			} else {
+				b.done(generation, err != nil && err != redis.Nil)
+			}
+		}
+	}
+	if err == ErrDeadlineExceeded {
+		atomic.AddInt64(&ks.stats.Timeouts, 1)
+	} else if err != nil && err != redis.Nil && err != ErrCircuitOpen {
+		atomic.AddInt64(&ks.stats.Errors, 1)
+	}
+	return err
+}
+
+//调用方的 ctx 超时或者取消，命令的结果不反映 Redis 是否可用
+func callerGaveUp(err error) bool {
+	return err == ErrDeadlineExceeded || err == context.DeadlineExceeded || err == context.Canceled
+}
@@ -0,0 +1,53 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"git.xiaojukeji.com/soda-framework/go-trace"
+	"php-thrift-go-server/client"
+)
+
+func TestKeyspace(t *testing.T) {
+	client.ShadowKeyPrefix = "_shadow_"
+	client.ShadowRedisClient = nil
+	defer func() {
+		client.ShadowKeyPrefix = ""
+	}()
+
+	ultronCtx := trace.NewContext(context.Background(), trace.Trace{"hintCode": "1"})
+	prodCtx := trace.NewContext(context.Background(), trace.Trace{"hintCode": "2"})
+
+	if ks := keyspaceOf(ultronCtx); ks != shadowKeyspace {
+		t.Fatalf("ultron request must use shadow keyspace. [actual:%v]", ks.name)
+	}
+
+	if ks := keyspaceOf(prodCtx); ks != prodKeyspace {
+		t.Fatalf("normal request must use prod keyspace. [actual:%v]", ks.name)
+	}
+
+	if key, err := shadowKeyspace.key("1"); err != nil || key != "_shadow_1" {
+		t.Fatalf("shadow key must be prefixed. [key:%v] [err:%v]", key, err)
+	}
+
+	if _, err := prodKeyspace.key("_shadow_1"); err == nil {
+		t.Fatalf("prod request must not access shadow key.")
+	}
+
+	// 影子 Redis 没有初始化时不能回退到线上 Redis。
+	before := KeyspaceStats()
+
+	if err := RedisSet(ultronCtx, "1", "user"); err != errShadowNotInitialized {
+		t.Fatalf("ultron request must not fall back to prod redis. [err:%v]", err)
+	}
+
+	after := KeyspaceStats()
+
+	if after["shadow"].Calls != before["shadow"].Calls+1 || after["shadow"].Errors != before["shadow"].Errors+1 {
+		t.Fatalf("shadow stats must be counted. [before:%+v] [after:%+v]", before, after)
+	}
+
+	if after["prod"] != before["prod"] {
+		t.Fatalf("prod stats must not be changed. [before:%+v] [after:%+v]", before, after)
+	}
+}
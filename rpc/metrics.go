@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"github.com/go-redis/redis"
+	"php-thrift-go-server/client"
+	"php-thrift-go-server/metrics"
+)
+
+var redisDuration = metrics.Default.NewHistogramVec("php_go_redis_command_duration_seconds",
+	"Redis command latency in seconds, excluding commands skipped by caller deadline.", nil, "keyspace", "command")
+
+func init() {
+	metrics.Default.NewCollector("php_go_redis_commands_total", "Total Redis commands.", "counter",
+		[]string{"keyspace"}, func(emit func(float64, ...string)) {
+			for _, ks := range keyspaces {
+				emit(float64(ks.stats.load().Calls), ks.name)
+			}
+		})
+	metrics.Default.NewCollector("php_go_redis_errors_total", "Total failed Redis commands, reason is error or timeout.", "counter",
+		[]string{"keyspace", "reason"}, func(emit func(float64, ...string)) {
+			for _, ks := range keyspaces {
+				stats := ks.stats.load()
+				emit(float64(stats.Errors), ks.name, "error")
+				emit(float64(stats.Timeouts), ks.name, "timeout")
+			}
+		})
+
+	//熔断器的状态，没有开启熔断时不输出
+	metrics.Default.NewCollector("php_go_redis_breaker_state", "Redis circuit breaker state, 0 closed, 1 open, 2 half open.", "gauge",
+		[]string{"keyspace"}, func(emit func(float64, ...string)) {
+			for _, ks := range keyspaces {
+				if ks.breaker != nil {
+					emit(float64(ks.breaker.currentState()), ks.name)
+				}
+			}
+		})
+	metrics.Default.NewCollector("php_go_redis_breaker_transitions_total", "Total Redis circuit breaker state changes by new state.", "counter",
+		[]string{"keyspace", "state"}, func(emit func(float64, ...string)) {
+			for _, ks := range keyspaces {
+				if ks.breaker != nil {
+					status := ks.breaker.status()
+					for _, state := range breakerStates {
+						emit(float64(status.Transitions[state.String()]), ks.name, state.String())
+					}
+				}
+			}
+		})
+	metrics.Default.NewCollector("php_go_redis_breaker_rejected_total", "Total Redis commands rejected by an open circuit breaker.", "counter",
+		[]string{"keyspace"}, func(emit func(float64, ...string)) {
+			for _, ks := range keyspaces {
+				if ks.breaker != nil {
+					emit(float64(ks.breaker.status().Rejected), ks.name)
+				}
+			}
+		})
+
+	//go-redis 连接池状态，影子 Redis 和线上共用实例时只输出 prod
+	poolStats := func(name, help, typ string, value func(*redis.PoolStats) uint32) {
+		metrics.Default.NewCollector(name, help, typ, []string{"keyspace"}, func(emit func(float64, ...string)) {
+			for _, ks := range keyspaces {
+				c, err := ks.client()
+				if err != nil || (ks.shadow && c == &client.RedisClient) {
+					continue
+				}
+				emit(float64(value(c.PoolStats())), ks.name)
+			}
+		})
+	}
+	poolStats("php_go_redis_pool_hits_total", "Times a free connection was found in the pool.", "counter",
+		func(s *redis.PoolStats) uint32 { return s.Hits })
+	poolStats("php_go_redis_pool_misses_total", "Times a free connection was not found in the pool.", "counter",
+		func(s *redis.PoolStats) uint32 { return s.Misses })
+	poolStats("php_go_redis_pool_timeouts_total", "Times waiting for a connection timed out.", "counter",
+		func(s *redis.PoolStats) uint32 { return s.Timeouts })
+	poolStats("php_go_redis_pool_conns", "Connections in the pool.", "gauge",
+		func(s *redis.PoolStats) uint32 { return s.TotalConns })
+	poolStats("php_go_redis_pool_idle_conns", "Idle connections in the pool.", "gauge",
+		func(s *redis.PoolStats) uint32 { return s.IdleConns })
+	poolStats("php_go_redis_pool_stale_conns_total", "Stale connections removed from the pool.", "counter",
+		func(s *redis.PoolStats) uint32 { return s.StaleConns })
+}
@@ -1,34 +1,105 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
 	"github.com/go-redis/redis"
 	"php-thrift-go-server/client"
+	"php-thrift-go-server/timing"
 	"php-thrift-go-server/util"
+	"time"
 )
 
-//能否ping通Redis
-func Ping()  {
-	pong, err := client.RedisClient.Ping().Result()
-	fmt.Println(pong,err)
+//调用方的超时时间已经用完，Redis 命令没有执行或者没有等到结果
+var ErrDeadlineExceeded = errors.New("caller deadline exceeded")
+
+//能否ping通Redis，影子 Redis 是单独的实例时也要能ping通，Redis 卡住时在 ctx 结束时返回 ErrDeadlineExceeded
+func Ping(ctx context.Context) error {
+	if err := process(ctx, &client.RedisClient, redis.NewStatusCmd("ping")); err != nil {
+		return err
+	}
+	if client.ShadowRedisClient != nil && client.ShadowRedisClient != &client.RedisClient {
+		if err := process(ctx, client.ShadowRedisClient, redis.NewStatusCmd("ping")); err != nil {
+			return fmt.Errorf("shadow: %v", err)
+		}
+	}
+	return nil
 }
 
 //key:value
-func RedisSet(key string, value interface{}) error {
+//Ultron 压测流量写到影子 keyspace
+func RedisSet(ctx context.Context, key string, value interface{}) error {
+	ks := keyspaceOf(ctx)
+	stop := timing.Start(ctx, "json_marshal")
 	str := util.JsonString(value)
-	err := client.RedisClient.Set(key, str, 0).Err()
+	stop()
+	key, err := ks.key(key)
+	if err == nil {
+		err = ks.process(ctx, redis.NewStatusCmd("set", key, str))
+	}
+	if err == ErrCircuitOpen {
+		log.Warnf("Rpc||RedisSet||%v||circuit breaker is open||keyspace=%v||key=%v", trace.ContextString(ctx), ks.name, key)
+	} else if err != nil {
+		log.Errorf("Rpc||RedisSet||%v||redis set error||keyspace=%v||key=%v||err=%v", trace.ContextString(ctx), ks.name, key, err)
+	}
 	return err
 }
 
-func RedisGet(key string) (val string, err error) {
-	val, err = client.RedisClient.Get(key).Result()
-	if err == redis.Nil {
+//Ultron 压测流量从影子 keyspace 读取
+func RedisGet(ctx context.Context, key string) (val string, err error) {
+	ks := keyspaceOf(ctx)
+	key, err = ks.key(key)
+	if err == nil {
+		cmd := redis.NewStringCmd("get", key)
+		if err = ks.process(ctx, cmd); err == nil {
+			val, err = cmd.Result()
+		}
+	}
+	if err == ErrDeadlineExceeded {
+		log.Warnf("Rpc||RedisGet||%v||caller deadline exceeded||keyspace=%v||key=%v", trace.ContextString(ctx), ks.name, key)
+		return "", err
+	} else if err == ErrCircuitOpen {
+		log.Warnf("Rpc||RedisGet||%v||circuit breaker is open||keyspace=%v||key=%v", trace.ContextString(ctx), ks.name, key)
+		return "", err
+	} else if err == redis.Nil {
+		log.Infof("Rpc||RedisGet||%v||key not exist||keyspace=%v||key=%v", trace.ContextString(ctx), ks.name, key)
 		return "", fmt.Errorf("key: %s not exist", key)
 	} else if err != nil {
+		log.Errorf("Rpc||RedisGet||%v||redis get error||keyspace=%v||key=%v||err=%v", trace.ContextString(ctx), ks.name, key, err)
 		return "", errors.New("redis internal error")
 	} else {
 		return
 	}
 
-}
\ No newline at end of file
+}
+
+//在 ctx 的 deadline 之前执行 Redis 命令，deadline 来自调用方 trace 中的 timeout。
+//go-redis 的命令不支持 ctx，超时之后直接返回，命令会在后台继续执行直到 Redis 的 ReadTimeout，
+//所以返回 ErrDeadlineExceeded 之后不能再读取 cmd 的结果。
+func process(ctx context.Context, c *redis.Client, cmd redis.Cmder) error {
+	if expired(ctx) {
+		return ErrDeadlineExceeded
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return c.Process(cmd)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Process(cmd)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrDeadlineExceeded
+	}
+}
+
+//ctx 的 deadline 是否已经过了，trace 的 timer 有精度误差，直接比较 deadline
+func expired(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ctx.Err() != nil || (ok && !time.Now().Before(deadline))
+}
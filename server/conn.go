@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// conn 包装一个客户端连接，记录连接上是否有正在处理的请求。
+// 优雅退出时只会主动关闭空闲的连接，正在处理请求的连接会在请求结束之后关闭。
+type conn struct {
+	thrift.TTransport
+
+	netConn net.Conn
+	busy    int32
+	closed  int32
+
+	// 等待新请求时使用 idleTimeout，读取请求的过程中使用 readTimeout，为 0 表示不限制。
+	idleTimeout     time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	readTimeoutKind string
+	violation       string // 第一次违规的类型，只在处理连接的 goroutine 里读写。
+
+	// 当前请求开始读到数据的时间、在连接上读写的字节数和响应写完之后的回调，只在处理连接的 goroutine 里读写。
+	requestStart  time.Time
+	bytesRead     int64
+	bytesWritten  int64
+	afterResponse []func(requestBytes, responseBytes int64)
+}
+
+func newConn(client thrift.TTransport, opts Options) *conn {
+	c := &conn{
+		TTransport:   client,
+		idleTimeout:  opts.IdleTimeout,
+		readTimeout:  opts.ReadTimeout,
+		writeTimeout: opts.WriteTimeout,
+	}
+
+	if socket, ok := client.(*thrift.TSocket); ok {
+		c.netConn = socket.Conn()
+	}
+
+	return c
+}
+
+// Read 和 Write 直接读写 netConn：TSocket 每次读写都会用自己的 timeout 覆盖 deadline。
+func (c *conn) Read(buf []byte) (int, error) {
+	if c.netConn == nil {
+		n, err := c.TTransport.Read(buf)
+		c.countRead(n)
+		return n, err
+	}
+
+	c.setReadDeadline()
+	n, err := c.netConn.Read(buf)
+	c.countRead(n)
+
+	if n > 0 {
+		atomic.StoreInt32(&c.busy, 1)
+	}
+
+	if isTimeout(err) {
+		c.violate(c.readTimeoutKind)
+	}
+
+	return n, thrift.NewTTransportExceptionFromError(err)
+}
+
+func (c *conn) Write(buf []byte) (int, error) {
+	if c.netConn == nil {
+		n, err := c.TTransport.Write(buf)
+		c.bytesWritten += int64(n)
+		return n, err
+	}
+
+	if c.writeTimeout > 0 {
+		c.netConn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+
+	n, err := c.netConn.Write(buf)
+	c.bytesWritten += int64(n)
+
+	if isTimeout(err) {
+		c.violate(ViolationWriteTimeout)
+	}
+
+	return n, thrift.NewTTransportExceptionFromError(err)
+}
+
+func (c *conn) setReadDeadline() {
+	timeout, kind := c.readTimeout, ViolationReadTimeout
+
+	if !c.isBusy() {
+		timeout, kind = c.idleTimeout, ViolationIdleTimeout
+	}
+
+	if timeout > 0 {
+		c.netConn.SetReadDeadline(time.Now().Add(timeout))
+		c.readTimeoutKind = kind
+	} else if c.readTimeoutKind != "" {
+		c.netConn.SetReadDeadline(time.Time{})
+		c.readTimeoutKind = ""
+	}
+}
+
+// violate 记录连接上的违规，只保留第一次。
+func (c *conn) violate(kind string) {
+	if c.violation == "" && kind != "" {
+		c.violation = kind
+	}
+}
+
+func (c *conn) countRead(n int) {
+	if n > 0 && c.requestStart.IsZero() {
+		c.requestStart = time.Now()
+	}
+
+	c.bytesRead += int64(n)
+}
+
+// done 标记当前请求已经处理完。
+func (c *conn) done() {
+	atomic.StoreInt32(&c.busy, 0)
+}
+
+// finishRequest 在 processor 写完响应之后调用，把这个请求读写的字节数交给 AfterResponse 注册的回调。
+func (c *conn) finishRequest() {
+	c.done()
+	callbacks, requestBytes, responseBytes := c.afterResponse, c.bytesRead, c.bytesWritten
+	c.afterResponse, c.bytesRead, c.bytesWritten = nil, 0, 0
+	c.requestStart = time.Time{}
+
+	for _, fn := range callbacks {
+		fn(requestBytes, responseBytes)
+	}
+}
+
+func (c *conn) isBusy() bool {
+	return atomic.LoadInt32(&c.busy) != 0
+}
+
+// isClosedByServer 判断连接是否被 server 主动关闭。
+func (c *conn) isClosedByServer() bool {
+	return atomic.LoadInt32(&c.closed) != 0
+}
+
+// forceClose 可以在任意 goroutine 里调用，让阻塞中的读写立即返回。
+func (c *conn) forceClose() {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+
+	if c.netConn != nil {
+		c.netConn.Close()
+		return
+	}
+
+	c.TTransport.Close()
+}
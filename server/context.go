@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+type connInfoOfContext struct{}
+
+var (
+	keyConnInfo = connInfoOfContext{}
+)
+
+// ConnInfo 描述请求所在的客户端连接。
+type ConnInfo struct {
+	Network    string // tcp、unix 或 http。
+	RemoteAddr string
+	LocalAddr  string
+	TLS        *tls.ConnectionState // 非 TLS 连接为 nil。
+
+	conn *conn // Server 处理的连接，thrift over HTTP 时为 nil。
+}
+
+// ClientIdentity 返回经过 CA 校验的客户端证书身份，优先使用 CommonName，其次是第一个 DNS SAN。
+// 没有开启客户端证书校验或者客户端没有提供证书时返回空字符串。
+func (info *ConnInfo) ClientIdentity() string {
+	if info == nil || info.TLS == nil || len(info.TLS.VerifiedChains) == 0 || len(info.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	cert := info.TLS.VerifiedChains[0][0]
+
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+
+	return ""
+}
+
+// NewConnContext 返回一个带有连接信息的 ctx。
+func NewConnContext(ctx context.Context, info *ConnInfo) context.Context {
+	return context.WithValue(ctx, keyConnInfo, info)
+}
+
+// ConnInfoFromContext 返回 ctx 中的连接信息，如果不存在则返回 nil。
+func ConnInfoFromContext(ctx context.Context) *ConnInfo {
+	info, _ := ctx.Value(keyConnInfo).(*ConnInfo)
+	return info
+}
+
+// AfterResponse 注册一个在当前请求的响应写完之后调用的函数，参数是这个请求在连接上读写的字节数，
+// 包括 transport 的 frame 头，buffered transport 预读的下一个请求的数据也会算在当前请求里。
+// 只能在处理请求的 goroutine 里调用。ctx 不是 Server 处理的连接（比如 thrift over HTTP）时返回 false，fn 不会被调用。
+func AfterResponse(ctx context.Context, fn func(requestBytes, responseBytes int64)) bool {
+	info := ConnInfoFromContext(ctx)
+
+	if info == nil || info.conn == nil {
+		return false
+	}
+
+	info.conn.afterResponse = append(info.conn.afterResponse, fn)
+	return true
+}
+
+// RequestStart 返回当前请求在连接上读到第一个字节的时间，用来计算解码请求的耗时。
+// buffered transport 已经预读了整个请求、或者 ctx 不是 Server 处理的连接时返回零值。
+// 只能在处理请求的 goroutine 里调用。
+func RequestStart(ctx context.Context) time.Time {
+	info := ConnInfoFromContext(ctx)
+
+	if info == nil || info.conn == nil {
+		return time.Time{}
+	}
+
+	return info.conn.requestStart
+}
+
+func newConnInfo(client thrift.TTransport) *ConnInfo {
+	info := &ConnInfo{}
+
+	if c, ok := client.(*conn); ok {
+		info.conn = c
+		client = c.TTransport
+	}
+
+	socket, ok := client.(*thrift.TSocket)
+
+	if !ok || socket.Conn() == nil {
+		return info
+	}
+
+	conn := socket.Conn()
+	info.Network = conn.LocalAddr().Network()
+	info.RemoteAddr = conn.RemoteAddr().String()
+	info.LocalAddr = conn.LocalAddr().String()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+	}
+
+	return info
+}
+
+// ContextProcessorFactory 为每个连接创建 processor，ctx 中带有 ConnInfo，
+// handler 可以通过 ConnInfoFromContext 拿到客户端地址、TLS 客户端身份等信息。
+type ContextProcessorFactory func(ctx context.Context) thrift.TProcessor
+
+func (f ContextProcessorFactory) GetProcessor(trans thrift.TTransport) thrift.TProcessor {
+	return f(NewConnContext(context.Background(), newConnInfo(trans)))
+}
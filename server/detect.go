@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// ProtocolAuto 表示根据连接的前几个字节自动识别协议和 transport。
+const ProtocolAuto = "auto"
+
+const (
+	binaryVersion1    = 0x80
+	binaryVersion2    = 0x01
+	compactProtocolID = 0x82
+	compactVersion    = 0x01
+	compactVersionMsk = 0x1f
+	jsonBegin         = '['
+	frameHeaderSize   = 4
+)
+
+var (
+	errUnknownProtocol = errors.New("unknown protocol")
+)
+
+// detection 是自动识别的结果。
+type detection struct {
+	protocol string // binary、compact 或 json。
+	strict   bool   // binary 协议是否带版本号。
+	framed   bool
+}
+
+func (d detection) String() string {
+	s := d.protocol
+
+	if d.protocol == ProtocolBinary && !d.strict {
+		s += "(non-strict)"
+	}
+
+	if d.framed {
+		return s + "+" + TransportFramed
+	}
+
+	return s + "+" + TransportBuffered
+}
+
+// factories 返回识别结果对应的 transport 和 protocol factory。
+// 非 framed 的连接统一使用 buffered transport，这对客户端是透明的。
+func (d detection) factories(bufferSize, maxFrameSize int) (thrift.TTransportFactory, thrift.TProtocolFactory) {
+	var transportFactory thrift.TTransportFactory
+	var protocolFactory thrift.TProtocolFactory
+
+	if d.framed {
+		transportFactory = newFramedTransportFactory(thrift.NewTTransportFactory(), maxFrameSize)
+	} else {
+		if bufferSize <= 0 {
+			bufferSize = DefaultBufferSize
+		}
+
+		transportFactory = &bufferedTransportFactory{
+			factory:    thrift.NewTTransportFactory(),
+			bufferSize: bufferSize,
+		}
+	}
+
+	switch d.protocol {
+	case ProtocolCompact:
+		protocolFactory = thrift.NewTCompactProtocolFactory()
+	case ProtocolJSON:
+		protocolFactory = thrift.NewTJSONProtocolFactory()
+	default:
+		protocolFactory = thrift.NewTBinaryProtocolFactory(false, d.strict)
+	}
+
+	return transportFactory, protocolFactory
+}
+
+// detect 通过 r.Peek 查看消息开头的几个字节识别协议，不会消耗 r 里的数据。
+//
+// 识别规则：
+//   - 0x80 0x01 开头是 strict binary，0x82 开头是 compact，'[' 开头是 JSON；
+//   - 否则前 4 字节可能是 frame 长度，用同样的规则检查第 5 个字节；
+//   - non-strict binary 以 4 字节方法名长度开头，后面紧跟方法名，用方法名首字母来区分是否 framed。
+func detect(r *bufio.Reader) (d detection, err error) {
+	buf, err := r.Peek(1)
+
+	if err != nil {
+		return
+	}
+
+	if d, ok := detectHeader(buf[0], r, 0); ok {
+		return d, nil
+	}
+
+	buf, err = r.Peek(frameHeaderSize + 1)
+
+	if err != nil {
+		return
+	}
+
+	if d, ok := detectHeader(buf[frameHeaderSize], r, frameHeaderSize); ok {
+		d.framed = true
+		return d, nil
+	}
+
+	// 首个字段是方法名长度，随后是方法名。
+	if isNameStart(buf[frameHeaderSize]) && binary.BigEndian.Uint32(buf) > 0 {
+		return detection{protocol: ProtocolBinary}, nil
+	}
+
+	buf, err = r.Peek(2*frameHeaderSize + 1)
+
+	if err != nil {
+		return
+	}
+
+	if isNameStart(buf[2*frameHeaderSize]) && binary.BigEndian.Uint32(buf[frameHeaderSize:]) > 0 {
+		return detection{protocol: ProtocolBinary, framed: true}, nil
+	}
+
+	err = fmt.Errorf("%v: % x", errUnknownProtocol, buf)
+	return
+}
+
+func detectHeader(b byte, r *bufio.Reader, offset int) (d detection, ok bool) {
+	switch b {
+	case binaryVersion1:
+		if buf, err := r.Peek(offset + 2); err == nil && buf[offset+1] == binaryVersion2 {
+			return detection{protocol: ProtocolBinary, strict: true}, true
+		}
+	case compactProtocolID:
+		if buf, err := r.Peek(offset + 2); err == nil && buf[offset+1]&compactVersionMsk == compactVersion {
+			return detection{protocol: ProtocolCompact}, true
+		}
+	case jsonBegin:
+		return detection{protocol: ProtocolJSON}, true
+	}
+
+	return
+}
+
+func isNameStart(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// peekedTransport 从 bufio.Reader 中读数据，写数据直接写到原始连接。
+type peekedTransport struct {
+	thrift.TTransport
+	reader *bufio.Reader
+}
+
+func newPeekedTransport(trans thrift.TTransport, bufferSize int) *peekedTransport {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &peekedTransport{
+		TTransport: trans,
+		reader:     bufio.NewReaderSize(trans, bufferSize),
+	}
+}
+
+func (p *peekedTransport) Read(buf []byte) (int, error) {
+	return p.reader.Read(buf)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+func TestDetect(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{}, Options{Detect: true})
+	defer svr.Stop()
+
+	framed := thrift.NewTFramedTransportFactory(thrift.NewTTransportFactory())
+	buffered := thrift.NewTBufferedTransportFactory(DefaultBufferSize)
+	cases := []struct {
+		name             string
+		transportFactory thrift.TTransportFactory
+		protocolFactory  thrift.TProtocolFactory
+	}{
+		{"binary+buffered", buffered, thrift.NewTBinaryProtocolFactoryDefault()},
+		{"binary+framed", framed, thrift.NewTBinaryProtocolFactoryDefault()},
+		{"binary(non-strict)+buffered", buffered, thrift.NewTBinaryProtocolFactory(false, false)},
+		{"binary(non-strict)+framed", framed, thrift.NewTBinaryProtocolFactory(false, false)},
+		{"compact+buffered", buffered, thrift.NewTCompactProtocolFactory()},
+		{"compact+framed", framed, thrift.NewTCompactProtocolFactory()},
+		{"json+buffered", buffered, thrift.NewTJSONProtocolFactory()},
+		{"json+framed", framed, thrift.NewTJSONProtocolFactory()},
+	}
+
+	for _, c := range cases {
+		socket, err := thrift.NewTSocketTimeout(addr, time.Second)
+
+		if err != nil {
+			t.Fatalf("fail to create socket. [err:%v]", err)
+		}
+
+		if err := socket.Open(); err != nil {
+			t.Fatalf("fail to open socket. [err:%v]", err)
+		}
+
+		client := idl.NewPhp_Go_SvrClientFactory(c.transportFactory.GetTransport(socket), c.protocolFactory)
+
+		for i := int32(1); i <= 2; i++ {
+			resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: i})
+
+			if err != nil {
+				t.Fatalf("fail to call GetUserByUserID. [case:%v] [err:%v]", c.name, err)
+			}
+
+			if resp.User.UserID != i {
+				t.Fatalf("invalid user id. [case:%v] [expected:%v] [actual:%v]", c.name, i, resp.User.UserID)
+			}
+		}
+
+		socket.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"compress/zlib"
+	"fmt"
+	"strings"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// 支持的协议名称。
+const (
+	ProtocolBinary     = "binary"
+	ProtocolCompact    = "compact"
+	ProtocolJSON       = "json"
+	ProtocolSimpleJSON = "simplejson"
+)
+
+// 支持的 transport 名称。
+const (
+	TransportPlain    = "plain"
+	TransportBuffered = "buffered"
+	TransportFramed   = "framed"
+)
+
+// DefaultBufferSize 是 buffered transport 的默认缓冲区大小。
+const DefaultBufferSize = 8192
+
+// TransportOptions 描述如何包装客户端连接。
+type TransportOptions struct {
+	Transport    string // plain、buffered 或者 framed，默认 plain。
+	BufferSize   int    // buffered transport 的缓冲区大小，默认 DefaultBufferSize。
+	Zlib         bool   // 是否在连接上使用 zlib 压缩，压缩层在 buffered/framed 之下。
+	ZlibLevel    int    // zlib 压缩级别，取值 -1 到 9。
+	MaxFrameSize int    // framed transport 单个 frame 的最大长度，默认使用 thrift 的上限。
+}
+
+// NewProtocolFactory 根据协议名称创建 protocol factory，名称为空时使用 binary。
+func NewProtocolFactory(name string) (thrift.TProtocolFactory, error) {
+	switch strings.ToLower(name) {
+	case "", ProtocolBinary:
+		return thrift.NewTBinaryProtocolFactoryDefault(), nil
+	case ProtocolCompact:
+		return thrift.NewTCompactProtocolFactory(), nil
+	case ProtocolJSON:
+		return thrift.NewTJSONProtocolFactory(), nil
+	case ProtocolSimpleJSON:
+		return thrift.NewTSimpleJSONProtocolFactory(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported protocol %q", name)
+}
+
+// NewTransportFactory 根据 opts 创建 transport factory。
+func NewTransportFactory(opts TransportOptions) (thrift.TTransportFactory, error) {
+	factory := thrift.NewTTransportFactory()
+
+	if opts.Zlib {
+		if opts.ZlibLevel < zlib.DefaultCompression || opts.ZlibLevel > zlib.BestCompression {
+			return nil, fmt.Errorf("invalid zlib level %v", opts.ZlibLevel)
+		}
+
+		factory = &zlibTransportFactory{
+			factory: factory,
+			level:   opts.ZlibLevel,
+		}
+	}
+
+	switch strings.ToLower(opts.Transport) {
+	case "", TransportPlain:
+	case TransportBuffered:
+		bufferSize := opts.BufferSize
+
+		if bufferSize <= 0 {
+			bufferSize = DefaultBufferSize
+		}
+
+		factory = &bufferedTransportFactory{
+			factory:    factory,
+			bufferSize: bufferSize,
+		}
+	case TransportFramed:
+		factory = newFramedTransportFactory(factory, opts.MaxFrameSize)
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", opts.Transport)
+	}
+
+	return factory, nil
+}
+
+// newFramedTransportFactory 创建 framed transport factory，maxFrameSize 为 0 时使用 thrift 的默认上限。
+func newFramedTransportFactory(factory thrift.TTransportFactory, maxFrameSize int) thrift.TTransportFactory {
+	if maxFrameSize <= 0 {
+		return thrift.NewTFramedTransportFactory(factory)
+	}
+
+	return thrift.NewTFramedTransportFactoryMaxLength(factory, uint32(maxFrameSize))
+}
+
+// zlibTransportFactory 和 thrift.TZlibTransportFactory 类似，但是可以包装其他 factory。
+type zlibTransportFactory struct {
+	factory thrift.TTransportFactory
+	level   int
+}
+
+func (p *zlibTransportFactory) GetTransport(trans thrift.TTransport) thrift.TTransport {
+	t, _ := thrift.NewTZlibTransport(p.factory.GetTransport(trans), p.level)
+	return t
+}
+
+// bufferedTransportFactory 和 thrift.TBufferedTransportFactory 类似，但是可以包装其他 factory。
+type bufferedTransportFactory struct {
+	factory    thrift.TTransportFactory
+	bufferSize int
+}
+
+func (p *bufferedTransportFactory) GetTransport(trans thrift.TTransport) thrift.TTransport {
+	return thrift.NewTBufferedTransport(p.factory.GetTransport(trans), p.bufferSize)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+func TestFactories(t *testing.T) {
+	cases := []struct {
+		protocol  string
+		transport TransportOptions
+	}{
+		{ProtocolBinary, TransportOptions{}},
+		{ProtocolCompact, TransportOptions{Transport: TransportFramed}},
+		{ProtocolJSON, TransportOptions{Transport: TransportBuffered}},
+		{ProtocolBinary, TransportOptions{Transport: TransportFramed, Zlib: true, ZlibLevel: 6}},
+	}
+
+	for _, c := range cases {
+		protocolFactory, err := NewProtocolFactory(c.protocol)
+
+		if err != nil {
+			t.Fatalf("fail to create protocol factory. [protocol:%v] [err:%v]", c.protocol, err)
+		}
+
+		transportFactory, err := NewTransportFactory(c.transport)
+
+		if err != nil {
+			t.Fatalf("fail to create transport factory. [transport:%+v] [err:%v]", c.transport, err)
+		}
+
+		svr, addr := startTestServerWithFactory(t, testHandler{}, transportFactory, protocolFactory, Options{})
+		socket, err := thrift.NewTSocketTimeout(addr, time.Second)
+
+		if err != nil {
+			t.Fatalf("fail to create socket. [err:%v]", err)
+		}
+
+		if err := socket.Open(); err != nil {
+			t.Fatalf("fail to open socket. [err:%v]", err)
+		}
+
+		client := idl.NewPhp_Go_SvrClientFactory(transportFactory.GetTransport(socket), protocolFactory)
+		resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 7})
+
+		if err != nil {
+			t.Fatalf("fail to call GetUserByUserID. [protocol:%v] [transport:%+v] [err:%v]", c.protocol, c.transport, err)
+		}
+
+		if resp.User.UserID != 7 {
+			t.Fatalf("invalid user id. [expected:7] [actual:%v]", resp.User.UserID)
+		}
+
+		socket.Close()
+		svr.Stop()
+	}
+}
+
+func TestFactoriesInvalidName(t *testing.T) {
+	if _, err := NewProtocolFactory("xml"); err == nil {
+		t.Fatalf("unknown protocol must fail.")
+	}
+
+	if _, err := NewTransportFactory(TransportOptions{Transport: "http"}); err == nil {
+		t.Fatalf("unknown transport must fail.")
+	}
+
+	if _, err := NewTransportFactory(TransportOptions{Zlib: true, ZlibLevel: 10}); err == nil {
+		t.Fatalf("invalid zlib level must fail.")
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+// HTTP 接口的默认参数。
+const (
+	DefaultHTTPPath         = "/"
+	DefaultHTTPReadTimeout  = 5 * time.Second
+	DefaultHTTPWriteTimeout = 5 * time.Second
+	DefaultHTTPIdleTimeout  = 60 * time.Second
+	DefaultHTTPMaxBodySize  = 4 << 20
+)
+
+// 请求和响应使用的 Content-Type。
+const (
+	ContentTypeThrift  = "application/x-thrift" // THttpClient 默认使用的类型，按照 binary 协议处理。
+	ContentTypeBinary  = "application/vnd.apache.thrift.binary"
+	ContentTypeCompact = "application/vnd.apache.thrift.compact"
+	ContentTypeJSON    = "application/vnd.apache.thrift.json"
+	ContentTypeAppJSON = "application/json"
+)
+
+// HTTPOptions 是 thrift over HTTP 接口的参数，零值表示使用默认值。
+type HTTPOptions struct {
+	Path         string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	MaxBodySize  int64
+}
+
+func (opts HTTPOptions) normalize() HTTPOptions {
+	if opts.Path == "" {
+		opts.Path = DefaultHTTPPath
+	}
+	if opts.ReadTimeout <= 0 {
+		opts.ReadTimeout = DefaultHTTPReadTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultHTTPWriteTimeout
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultHTTPIdleTimeout
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultHTTPMaxBodySize
+	}
+	return opts
+}
+
+// NewHTTPServer 创建一个通过 HTTP POST 提供 thrift 服务的 http.Server。
+func NewHTTPServer(addr string, processorFactory thrift.TProcessorFactory, opts HTTPOptions) *http.Server {
+	opts = opts.normalize()
+	mux := http.NewServeMux()
+	mux.Handle(opts.Path, NewHTTPHandler(processorFactory, opts))
+
+	return &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+}
+
+// NewHTTPHandler 返回处理 thrift 请求的 http.Handler，语义与 THttpClient 兼容：
+// 每个 POST 请求体是一个完整的 thrift 消息，响应体是对应的回复，协议由 Content-Type 决定。
+func NewHTTPHandler(processorFactory thrift.TProcessorFactory, opts HTTPOptions) http.Handler {
+	return &httpHandler{
+		processorFactory: processorFactory,
+		opts:             opts.normalize(),
+	}
+}
+
+type httpHandler struct {
+	processorFactory thrift.TProcessorFactory
+	opts             HTTPOptions
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, protocolFactory := httpProtocol(r.Header.Get("Content-Type"))
+
+	if protocolFactory == nil {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if r.ContentLength > h.opts.MaxBodySize {
+		log.Warnf("Server||http request body too large||peer=%v||size=%v||limit=%v", r.RemoteAddr, r.ContentLength, h.opts.MaxBodySize)
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, h.opts.MaxBodySize+1))
+
+	if err != nil {
+		log.Warnf("Server||fail to read http request body||peer=%v||err=%v", r.RemoteAddr, err)
+		http.Error(w, "fail to read request body", http.StatusBadRequest)
+		return
+	}
+
+	if int64(len(body)) > h.opts.MaxBodySize {
+		log.Warnf("Server||http request body too large||peer=%v||limit=%v", r.RemoteAddr, h.opts.MaxBodySize)
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	output := &bytes.Buffer{}
+	transport := thrift.NewStreamTransport(bytes.NewReader(body), output)
+	var processor thrift.TProcessor
+
+	if factory, ok := h.processorFactory.(ContextProcessorFactory); ok {
+		processor = factory(NewConnContext(r.Context(), &ConnInfo{
+			Network:    "http",
+			RemoteAddr: r.RemoteAddr,
+			LocalAddr:  localAddr(r),
+			TLS:        r.TLS,
+		}))
+	} else {
+		processor = h.processorFactory.GetProcessor(transport)
+	}
+
+	if _, err := processor.Process(protocolFactory.GetProtocol(transport), protocolFactory.GetProtocol(transport)); err != nil {
+		log.Warnf("Server||error processing http request||peer=%v||err=%v", r.RemoteAddr, err)
+
+		// 处理失败并且没有任何输出，说明请求本身无法解析。
+		if output.Len() == 0 {
+			http.Error(w, "bad thrift request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(output.Len()))
+	w.Write(output.Bytes())
+}
+
+// httpProtocol 根据请求的 Content-Type 选择协议，返回响应使用的 Content-Type。
+func httpProtocol(contentType string) (string, thrift.TProtocolFactory) {
+	mediaType := ""
+
+	if contentType != "" {
+		mediaType, _, _ = mime.ParseMediaType(contentType)
+	}
+
+	switch mediaType {
+	case "", ContentTypeThrift:
+		return ContentTypeThrift, thrift.NewTBinaryProtocolFactoryDefault()
+	case ContentTypeBinary:
+		return ContentTypeBinary, thrift.NewTBinaryProtocolFactoryDefault()
+	case ContentTypeCompact:
+		return ContentTypeCompact, thrift.NewTCompactProtocolFactory()
+	case ContentTypeJSON, ContentTypeAppJSON:
+		return mediaType, thrift.NewTJSONProtocolFactory()
+	}
+
+	return "", nil
+}
+
+func localAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr.String()
+	}
+
+	return ""
+}
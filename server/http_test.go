@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	processor := idl.NewPhp_Go_SvrProcessor(testHandler{})
+	ts := httptest.NewServer(NewHTTPHandler(thrift.NewTProcessorFactory(processor), HTTPOptions{MaxBodySize: 1024}))
+	defer ts.Close()
+
+	cases := []struct {
+		contentType     string
+		protocolFactory thrift.TProtocolFactory
+	}{
+		{ContentTypeThrift, thrift.NewTBinaryProtocolFactoryDefault()},
+		{ContentTypeJSON, thrift.NewTJSONProtocolFactory()},
+		{ContentTypeCompact, thrift.NewTCompactProtocolFactory()},
+	}
+
+	for _, c := range cases {
+		trans, err := thrift.NewTHttpPostClient(ts.URL)
+
+		if err != nil {
+			t.Fatalf("fail to create http client. [err:%v]", err)
+		}
+
+		trans.(*thrift.THttpClient).DelHeader("Content-Type")
+		trans.(*thrift.THttpClient).SetHeader("Content-Type", c.contentType)
+		client := idl.NewPhp_Go_SvrClientFactory(trans, c.protocolFactory)
+		resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 3})
+
+		if err != nil {
+			t.Fatalf("fail to call GetUserByUserID. [content-type:%v] [err:%v]", c.contentType, err)
+		}
+
+		if resp.User.UserID != 3 {
+			t.Fatalf("invalid user id. [expected:3] [actual:%v]", resp.User.UserID)
+		}
+	}
+
+	// 非 POST 请求和过大的请求体都应该被拒绝。
+	if resp, err := http.Get(ts.URL); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET must be rejected. [resp:%v] [err:%v]", resp, err)
+	}
+
+	body := bytes.NewBufferString(strings.Repeat("x", 2048))
+
+	if resp, err := http.Post(ts.URL, ContentTypeThrift, body); err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large body must be rejected. [resp:%v] [err:%v]", resp, err)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+// 继承 listener 使用的环境变量，LISTEN_FDS、LISTEN_PID 和 LISTEN_FDNAMES 与 systemd socket activation 一致，
+// LISTEN_PARENT_PID 是热重启时父进程的 pid，子进程开始服务之后通知父进程退出。
+const (
+	envListenFDs       = "LISTEN_FDS"
+	envListenPID       = "LISTEN_PID"
+	envListenFDNames   = "LISTEN_FDNAMES"
+	envListenParentPID = "LISTEN_PARENT_PID"
+
+	listenFDsStart = 3
+)
+
+// ListenerSet 管理进程的所有监听 socket。
+// 启动时优先使用从 systemd 或者父进程继承的 listener，没有继承到再自己监听；
+// 热重启时把所有 listener 交给新进程，新进程开始服务之后通知旧进程退出，整个过程端口一直处于监听状态。
+type ListenerSet struct {
+	mu        sync.Mutex
+	inherited []*namedListener
+	active    []*namedListener
+	parentPID int
+}
+
+type namedListener struct {
+	name string
+	net.Listener
+}
+
+// NewListenerSet 读取环境变量中继承的 listener，读取之后清除这些环境变量，避免被再下一级的子进程误用。
+func NewListenerSet() (*ListenerSet, error) {
+	ls := &ListenerSet{}
+
+	defer func() {
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envListenPID)
+		os.Unsetenv(envListenFDNames)
+		os.Unsetenv(envListenParentPID)
+	}()
+
+	// systemd 会设置 LISTEN_PID，不是当前进程说明这些 fd 不是给我们的。
+	if pid := os.Getenv(envListenPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return ls, nil
+	}
+
+	count, _ := strconv.Atoi(os.Getenv(envListenFDs))
+
+	if count <= 0 {
+		return ls, nil
+	}
+
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		name := ""
+
+		if i < len(names) {
+			name = names[i]
+		}
+
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			ls.closeInherited()
+			return nil, fmt.Errorf("fail to inherit listener %d (%v): %v", fd, name, err)
+		}
+
+		ls.inherited = append(ls.inherited, &namedListener{name: name, Listener: ln})
+	}
+
+	ls.parentPID, _ = strconv.Atoi(os.Getenv(envListenParentPID))
+	log.Infof("Server||inherit listeners||count=%v||names=%v||parent=%v", count, names, ls.parentPID)
+	return ls, nil
+}
+
+// Listen 返回名字为 name 的 listener。优先使用继承的 listener，先按名字匹配，再按地址匹配；
+// 没有继承到时调用 listen 创建，listen 为 nil 时使用 net.Listen(network, addr)。
+func (ls *ListenerSet) Listen(name, network, addr string, listen func() (net.Listener, error)) (net.Listener, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ln := ls.takeInherited(name, network, addr)
+
+	if ln == nil {
+		var err error
+
+		if listen == nil {
+			listen = func() (net.Listener, error) {
+				return net.Listen(network, addr)
+			}
+		}
+
+		if ln, err = listen(); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Infof("Server||use inherited listener||name=%v||addr=%v", name, ln.Addr())
+	}
+
+	ls.active = append(ls.active, &namedListener{name: name, Listener: ln})
+	return ln, nil
+}
+
+func (ls *ListenerSet) takeInherited(name, network, addr string) net.Listener {
+	match := -1
+
+	for i, inherited := range ls.inherited {
+		if inherited.name == name {
+			match = i
+			break
+		}
+
+		if match < 0 && sameAddr(inherited.Addr(), network, addr) {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return nil
+	}
+
+	ln := ls.inherited[match].Listener
+	ls.inherited = append(ls.inherited[:match], ls.inherited[match+1:]...)
+	return ln
+}
+
+func sameAddr(a net.Addr, network, addr string) bool {
+	if a.Network() != network {
+		return false
+	}
+
+	if network == "unix" {
+		return a.String() == addr
+	}
+
+	tcpAddr, ok := a.(*net.TCPAddr)
+	expected, err := net.ResolveTCPAddr(network, addr)
+
+	if !ok || err != nil || tcpAddr.Port != expected.Port {
+		return false
+	}
+
+	return expected.IP == nil || (expected.IP.IsUnspecified() && tcpAddr.IP.IsUnspecified()) || tcpAddr.IP.Equal(expected.IP)
+}
+
+// CloseUnused 关闭继承了但是没有用到的 listener，在所有 Listen 调用之后调用。
+func (ls *ListenerSet) CloseUnused() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.closeInherited()
+}
+
+func (ls *ListenerSet) closeInherited() {
+	for _, ln := range ls.inherited {
+		log.Warnf("Server||close unused inherited listener||name=%v||addr=%v", ln.name, ln.Addr())
+		ln.Close()
+	}
+
+	ls.inherited = nil
+}
+
+// NotifyParent 在新进程开始服务之后调用，通知热重启的父进程停止接受新连接、处理完请求后退出。
+func (ls *ListenerSet) NotifyParent() error {
+	if ls.parentPID <= 0 {
+		return nil
+	}
+
+	log.Infof("Server||notify parent to exit||parent=%v", ls.parentPID)
+	return syscall.Kill(ls.parentPID, syscall.SIGTERM)
+}
+
+// StartProcess 使用相同的参数启动一个新进程，并把所有 listener 传给它。
+// 新进程开始服务之后会通过 SIGTERM 通知当前进程退出，当前进程在此之前继续正常服务。
+func (ls *ListenerSet) StartProcess() (*os.Process, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	path, err := os.Executable()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.Getwd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	names := make([]string, 0, len(ls.active))
+
+	defer func() {
+		for _, file := range files[listenFDsStart:] {
+			file.Close()
+		}
+	}()
+
+	for _, ln := range ls.active {
+		filer, ok := ln.Listener.(interface {
+			File() (*os.File, error)
+		})
+
+		if !ok {
+			return nil, fmt.Errorf("listener %v does not support file handoff", ln.name)
+		}
+
+		file, err := filer.File()
+
+		if err != nil {
+			return nil, fmt.Errorf("fail to get file of listener %v: %v", ln.name, err)
+		}
+
+		files = append(files, file)
+		names = append(names, ln.name)
+	}
+
+	// 旧进程关闭 unix listener 的时候不能删除 socket 文件，新进程还在使用。
+	for _, ln := range ls.active {
+		if unixLn, ok := ln.Listener.(*net.UnixListener); ok {
+			unixLn.SetUnlinkOnClose(false)
+		}
+	}
+
+	env := make([]string, 0, len(os.Environ())+3)
+
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "LISTEN_") {
+			env = append(env, kv)
+		}
+	}
+
+	env = append(env,
+		envListenFDs+"="+strconv.Itoa(len(names)),
+		envListenFDNames+"="+strings.Join(names, ":"),
+		envListenParentPID+"="+strconv.Itoa(os.Getpid()),
+	)
+
+	process, err := os.StartProcess(path, os.Args, &os.ProcAttr{
+		Dir:   dir,
+		Env:   env,
+		Files: files,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Server||start new process||pid=%v||listeners=%v", process.Pid, names)
+	return process, nil
+}
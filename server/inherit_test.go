@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenerSetInherit(t *testing.T) {
+	thriftLn, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	// systemd 没有配置 FileDescriptorName 时名字不匹配，按地址匹配。
+	httpLn, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	unusedLn, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	ls := &ListenerSet{
+		inherited: []*namedListener{
+			{name: "unknown", Listener: httpLn},
+			{name: "thrift", Listener: thriftLn},
+			{name: "unknown", Listener: unusedLn},
+		},
+	}
+
+	ln, err := ls.Listen("thrift", "tcp", "localhost:8999", nil)
+
+	if err != nil || ln != thriftLn {
+		t.Fatalf("listener must be matched by name. [ln:%v] [err:%v]", ln, err)
+	}
+
+	ln, err = ls.Listen("http", "tcp", httpLn.Addr().String(), nil)
+
+	if err != nil || ln != httpLn {
+		t.Fatalf("listener must be matched by address. [ln:%v] [err:%v]", ln, err)
+	}
+
+	ln, err = ls.Listen("admin", "tcp", "127.0.0.1:0", nil)
+
+	if err != nil || ln == unusedLn {
+		t.Fatalf("new listener must be created. [ln:%v] [err:%v]", ln, err)
+	}
+
+	defer ln.Close()
+
+	if len(ls.active) != 3 {
+		t.Fatalf("all listeners must be tracked for handoff. [actual:%v]", len(ls.active))
+	}
+
+	ls.CloseUnused()
+
+	if _, err := unusedLn.Accept(); err == nil {
+		t.Fatalf("unused inherited listener must be closed.")
+	}
+
+	thriftLn.Close()
+	httpLn.Close()
+}
+
+func TestSameAddr(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4zero, Port: 8999}
+
+	if !sameAddr(addr, "tcp", ":8999") || !sameAddr(addr, "tcp", "0.0.0.0:8999") {
+		t.Fatalf("wildcard address must match.")
+	}
+
+	if sameAddr(addr, "tcp", ":8998") || sameAddr(addr, "unix", ":8999") {
+		t.Fatalf("different port or network must not match.")
+	}
+
+	if !sameAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8999}, "tcp", "127.0.0.1:8999") {
+		t.Fatalf("same address must match.")
+	}
+}
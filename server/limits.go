@@ -0,0 +1,253 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// 连接上的违规类型，用于日志和计数。
+const (
+	ViolationIdleTimeout   = "idle_timeout"
+	ViolationReadTimeout   = "read_timeout"
+	ViolationWriteTimeout  = "write_timeout"
+	ViolationFrameSize     = "frame_size"
+	ViolationMessageSize   = "message_size"
+	ViolationStringSize    = "string_size"
+	ViolationContainerSize = "container_size"
+)
+
+// ViolationKinds 是所有的违规类型。
+var ViolationKinds = []string{
+	ViolationIdleTimeout,
+	ViolationReadTimeout,
+	ViolationWriteTimeout,
+	ViolationFrameSize,
+	ViolationMessageSize,
+	ViolationStringSize,
+	ViolationContainerSize,
+}
+
+// Violations 是各类违规的累计次数。
+type Violations struct {
+	IdleTimeout   int64
+	ReadTimeout   int64
+	WriteTimeout  int64
+	FrameSize     int64
+	MessageSize   int64
+	StringSize    int64
+	ContainerSize int64
+}
+
+func (v *Violations) counter(kind string) *int64 {
+	switch kind {
+	case ViolationIdleTimeout:
+		return &v.IdleTimeout
+	case ViolationReadTimeout:
+		return &v.ReadTimeout
+	case ViolationWriteTimeout:
+		return &v.WriteTimeout
+	case ViolationFrameSize:
+		return &v.FrameSize
+	case ViolationMessageSize:
+		return &v.MessageSize
+	case ViolationStringSize:
+		return &v.StringSize
+	case ViolationContainerSize:
+		return &v.ContainerSize
+	}
+
+	return nil
+}
+
+// Get 返回 kind 类型违规的次数。
+func (v Violations) Get(kind string) int64 {
+	if counter := v.counter(kind); counter != nil {
+		return *counter
+	}
+
+	return 0
+}
+
+func (v *Violations) add(kind string) {
+	if counter := v.counter(kind); counter != nil {
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+func (v *Violations) load() Violations {
+	return Violations{
+		IdleTimeout:   atomic.LoadInt64(&v.IdleTimeout),
+		ReadTimeout:   atomic.LoadInt64(&v.ReadTimeout),
+		WriteTimeout:  atomic.LoadInt64(&v.WriteTimeout),
+		FrameSize:     atomic.LoadInt64(&v.FrameSize),
+		MessageSize:   atomic.LoadInt64(&v.MessageSize),
+		StringSize:    atomic.LoadInt64(&v.StringSize),
+		ContainerSize: atomic.LoadInt64(&v.ContainerSize),
+	}
+}
+
+// limitedTransport 限制单个消息最多读取 maxMessageSize 个字节，
+// 在 limitedProtocol 读到新消息开头的时候重新计数。
+type limitedTransport struct {
+	thrift.TTransport
+
+	conn           *conn
+	maxMessageSize int64
+	read           int64
+	capped         bool // 最近一次 RemainingBytes 的结果是消息大小限制，而不是底层 transport 剩下的字节数。
+}
+
+func (t *limitedTransport) reset() {
+	t.read = 0
+}
+
+// RemainingBytes 返回当前消息最多还能读多少字节。
+// protocol 读字符串时先用声明的长度和它比较，超过时直接返回错误，不会按声明的长度分配内存。
+func (t *limitedTransport) RemainingBytes() uint64 {
+	remaining := t.TTransport.RemainingBytes()
+	t.capped = false
+
+	if t.maxMessageSize <= 0 {
+		return remaining
+	}
+
+	limit := t.maxMessageSize - t.read
+
+	if limit < 0 {
+		limit = 0
+	}
+
+	if uint64(limit) < remaining {
+		remaining, t.capped = uint64(limit), true
+	}
+
+	return remaining
+}
+
+func (t *limitedTransport) Read(buf []byte) (int, error) {
+	if t.maxMessageSize > 0 {
+		remaining := t.maxMessageSize - t.read
+
+		if remaining <= 0 {
+			t.conn.violate(ViolationMessageSize)
+			return 0, thrift.NewTTransportException(thrift.UNKNOWN_TRANSPORT_EXCEPTION,
+				fmt.Sprintf("message size exceeds limit %d", t.maxMessageSize))
+		}
+
+		if int64(len(buf)) > remaining {
+			buf = buf[:remaining]
+		}
+	}
+
+	n, err := t.TTransport.Read(buf)
+	t.read += int64(n)
+
+	// TFramedTransport 的 frame 长度超过上限时返回的错误没有单独的类型，只能按错误信息判断。
+	if err != nil && strings.HasPrefix(err.Error(), "Incorrect frame size") {
+		t.conn.violate(ViolationFrameSize)
+	}
+
+	return n, err
+}
+
+// limitedProtocol 在解码时检查字符串和容器的大小。
+// 容器在分配内存之前检查；字符串声明的长度超过消息剩下的大小时 protocol 在分配内存之前拒绝，
+// 所以一个字符串最多分配 maxMessageSize 个字节，读完之后再检查 maxStringSize。
+type limitedProtocol struct {
+	thrift.TProtocol
+
+	trans            *limitedTransport
+	maxStringSize    int
+	maxContainerSize int
+}
+
+func (p *limitedProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	p.trans.reset()
+	return p.TProtocol.ReadMessageBegin()
+}
+
+func (p *limitedProtocol) ReadString() (string, error) {
+	p.trans.capped = false
+	value, err := p.TProtocol.ReadString()
+
+	if err == nil {
+		err = p.checkString(len(value))
+	} else {
+		p.checkCapped(err)
+	}
+
+	return value, err
+}
+
+func (p *limitedProtocol) ReadBinary() ([]byte, error) {
+	p.trans.capped = false
+	value, err := p.TProtocol.ReadBinary()
+
+	if err == nil {
+		err = p.checkString(len(value))
+	} else {
+		p.checkCapped(err)
+	}
+
+	return value, err
+}
+
+// checkCapped 在读字符串失败之后调用，声明的长度超过了消息剩下的大小时记为消息大小违规。
+func (p *limitedProtocol) checkCapped(err error) {
+	if e, ok := err.(thrift.TProtocolException); ok && e.TypeId() == thrift.INVALID_DATA && p.trans.capped {
+		p.trans.conn.violate(ViolationMessageSize)
+	}
+}
+
+func (p *limitedProtocol) ReadListBegin() (thrift.TType, int, error) {
+	elemType, size, err := p.TProtocol.ReadListBegin()
+
+	if err == nil {
+		err = p.checkContainer(size)
+	}
+
+	return elemType, size, err
+}
+
+func (p *limitedProtocol) ReadSetBegin() (thrift.TType, int, error) {
+	elemType, size, err := p.TProtocol.ReadSetBegin()
+
+	if err == nil {
+		err = p.checkContainer(size)
+	}
+
+	return elemType, size, err
+}
+
+func (p *limitedProtocol) ReadMapBegin() (thrift.TType, thrift.TType, int, error) {
+	keyType, valueType, size, err := p.TProtocol.ReadMapBegin()
+
+	if err == nil {
+		err = p.checkContainer(size)
+	}
+
+	return keyType, valueType, size, err
+}
+
+func (p *limitedProtocol) checkString(size int) error {
+	if p.maxStringSize <= 0 || size <= p.maxStringSize {
+		return nil
+	}
+
+	p.trans.conn.violate(ViolationStringSize)
+	return thrift.NewTProtocolExceptionWithType(thrift.SIZE_LIMIT,
+		fmt.Errorf("string size %d exceeds limit %d", size, p.maxStringSize))
+}
+
+func (p *limitedProtocol) checkContainer(size int) error {
+	if p.maxContainerSize <= 0 || size <= p.maxContainerSize {
+		return nil
+	}
+
+	p.trans.conn.violate(ViolationContainerSize)
+	return thrift.NewTProtocolExceptionWithType(thrift.SIZE_LIMIT,
+		fmt.Errorf("container size %d exceeds limit %d", size, p.maxContainerSize))
+}
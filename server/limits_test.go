@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+func waitViolations(svr *Server, check func(Violations) bool) bool {
+	for i := 0; i < 100; i++ {
+		if check(svr.Violations()) {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{}, Options{IdleTimeout: 50 * time.Millisecond})
+	defer svr.Stop()
+
+	client, socket := newTestClient(t, addr)
+	defer socket.Close()
+
+	if _, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil {
+		t.Fatalf("fail to call GetUserByUserID. [err:%v]", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err == nil {
+		t.Fatalf("idle connection must be closed.")
+	}
+
+	if !waitViolations(svr, func(v Violations) bool { return v.IdleTimeout == 1 }) {
+		t.Fatalf("idle timeout must be counted. [violations:%+v]", svr.Violations())
+	}
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{}, Options{ReadTimeout: 50 * time.Millisecond})
+	defer svr.Stop()
+
+	_, socket := newTestClient(t, addr)
+	defer socket.Close()
+
+	// 只发送半个请求。
+	socket.Write([]byte{0x80, 0x01, 0x00, 0x01})
+	socket.Flush()
+
+	if !waitViolations(svr, func(v Violations) bool { return v.ReadTimeout == 1 }) {
+		t.Fatalf("read timeout must be counted. [violations:%+v]", svr.Violations())
+	}
+}
+
+func TestServerSizeLimits(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{}, Options{MaxStringSize: 64, MaxMessageSize: 1024})
+	defer svr.Stop()
+
+	client, socket := newTestClient(t, addr)
+
+	if _, err := client.SetUsers(&idl.SetUsersReq{UserInfoStr: strings.Repeat("a", 32)}); err != nil {
+		t.Fatalf("fail to call SetUsers. [err:%v]", err)
+	}
+
+	if _, err := client.SetUsers(&idl.SetUsersReq{UserInfoStr: strings.Repeat("a", 128)}); err == nil {
+		t.Fatalf("string over limit must be rejected.")
+	}
+
+	socket.Close()
+	client, socket = newTestClient(t, addr)
+	defer socket.Close()
+
+	if _, err := client.SetUsers(&idl.SetUsersReq{UserInfoStr: strings.Repeat("a", 4096)}); err == nil {
+		t.Fatalf("message over limit must be rejected.")
+	}
+
+	if !waitViolations(svr, func(v Violations) bool { return v.StringSize == 1 && v.MessageSize == 1 }) {
+		t.Fatalf("size violations must be counted. [violations:%+v]", svr.Violations())
+	}
+}
+
+func TestLimitedProtocolContainer(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	out := thrift.NewTBinaryProtocolTransport(buf)
+	out.WriteListBegin(thrift.I32, 1000)
+	out.WriteMapBegin(thrift.STRING, thrift.I32, 10)
+
+	trans := &limitedTransport{TTransport: buf, conn: &conn{}}
+	in := &limitedProtocol{
+		TProtocol:        thrift.NewTBinaryProtocolTransport(trans),
+		trans:            trans,
+		maxContainerSize: 100,
+	}
+
+	if _, _, err := in.ReadListBegin(); err == nil {
+		t.Fatalf("list over limit must be rejected.")
+	}
+
+	if trans.conn.violation != ViolationContainerSize {
+		t.Fatalf("invalid violation. [actual:%v]", trans.conn.violation)
+	}
+
+	if _, _, size, err := in.ReadMapBegin(); err != nil || size != 10 {
+		t.Fatalf("map under limit must be accepted. [size:%v] [err:%v]", size, err)
+	}
+}
+
+// 声明的字符串长度超过消息大小限制时在分配内存之前拒绝。
+// StreamTransport 和 socket 一样不知道剩下多少字节，只能靠 limitedTransport.RemainingBytes。
+func TestLimitedProtocolDeclaredStringSize(t *testing.T) {
+	protocols := map[string]func(thrift.TTransport) thrift.TProtocol{
+		"binary": func(trans thrift.TTransport) thrift.TProtocol {
+			return thrift.NewTBinaryProtocolTransport(trans)
+		},
+		"compact": func(trans thrift.TTransport) thrift.TProtocol {
+			return thrift.NewTCompactProtocol(trans)
+		},
+	}
+
+	for name, newProtocol := range protocols {
+		for _, read := range []string{"string", "binary"} {
+			buf := thrift.NewTMemoryBuffer()
+			out := newProtocol(buf)
+			out.WriteMessageBegin("SetUsers", thrift.CALL, 1)
+			// 只写长度，声明 1GB 的字符串。
+			if name == "binary" {
+				out.WriteI32(1 << 30)
+			} else {
+				buf.Write([]byte{0x80, 0x80, 0x80, 0x80, 0x04})
+			}
+
+			trans := &limitedTransport{TTransport: thrift.NewStreamTransportR(bytes.NewReader(buf.Bytes())), conn: &conn{}, maxMessageSize: 1024}
+			in := &limitedProtocol{TProtocol: newProtocol(trans), trans: trans, maxStringSize: 64}
+
+			if _, _, _, err := in.ReadMessageBegin(); err != nil {
+				t.Fatalf("fail to read message begin. [protocol:%v] [err:%v]", name, err)
+			}
+
+			var err error
+
+			if read == "string" {
+				_, err = in.ReadString()
+			} else {
+				_, err = in.ReadBinary()
+			}
+
+			if err == nil {
+				t.Fatalf("declared length over message limit must be rejected. [protocol:%v] [read:%v]", name, read)
+			}
+
+			if trans.conn.violation != ViolationMessageSize {
+				t.Fatalf("invalid violation. [protocol:%v] [read:%v] [actual:%v]", name, read, trans.conn.violation)
+			}
+		}
+	}
+}
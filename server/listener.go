@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+// ServerSocket 是基于一个已经创建好的 net.Listener 的 thrift.TServerTransport。
+// thrift.TServerSocket 只能自己监听 TCP 地址，使用 ServerSocket 可以接入 TLS、unix socket 等任意 listener。
+type ServerSocket struct {
+	listener      net.Listener
+	clientTimeout time.Duration
+
+	mu          sync.RWMutex
+	interrupted bool
+}
+
+// NewServerSocket 用 listener 创建 ServerSocket，clientTimeout 是每个客户端连接的读写超时。
+func NewServerSocket(listener net.Listener, clientTimeout time.Duration) *ServerSocket {
+	return &ServerSocket{
+		listener:      listener,
+		clientTimeout: clientTimeout,
+	}
+}
+
+// Listen 什么都不做，listener 在创建 ServerSocket 之前就已经在监听了。
+func (p *ServerSocket) Listen() error {
+	return nil
+}
+
+func (p *ServerSocket) Accept() (thrift.TTransport, error) {
+	p.mu.RLock()
+	interrupted := p.interrupted
+	p.mu.RUnlock()
+
+	if interrupted {
+		return nil, thrift.NewTTransportException(thrift.NOT_OPEN, "Transport Interrupted")
+	}
+
+	conn, err := p.listener.Accept()
+
+	if err != nil {
+		return nil, thrift.NewTTransportExceptionFromError(err)
+	}
+
+	return thrift.NewTSocketFromConnTimeout(conn, p.clientTimeout), nil
+}
+
+func (p *ServerSocket) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// Listener 返回底层的 net.Listener。
+func (p *ServerSocket) Listener() net.Listener {
+	return p.listener
+}
+
+func (p *ServerSocket) Close() error {
+	return p.listener.Close()
+}
+
+func (p *ServerSocket) Interrupt() error {
+	p.mu.Lock()
+	p.interrupted = true
+	p.mu.Unlock()
+
+	return p.Close()
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+// MultiListener 把多个 net.Listener 合并成一个，让 TCP 和 unix socket 共用同一个 Server、
+// 同一组 worker 和连接数限制。Addr 返回第一个 listener 的地址。
+// 一个 listener 出现不可恢复的错误时只停掉这个 listener，其他 listener 继续 Accept，
+// 所有 listener 都停掉之后 Accept 才返回最后一个错误。
+type MultiListener struct {
+	listeners []net.Listener
+	conns     chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	active int
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// NewMultiListener 合并 listeners，listeners 不能为空。
+func NewMultiListener(listeners ...net.Listener) *MultiListener {
+	ml := &MultiListener{
+		listeners: listeners,
+		conns:     make(chan acceptResult),
+		closed:    make(chan struct{}),
+		active:    len(listeners),
+	}
+
+	for _, ln := range listeners {
+		go ml.accept(ln)
+	}
+
+	return ml
+}
+
+func (ml *MultiListener) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+
+		// listener 已经不能用了，不再 Accept。还有其他 listener 时不把错误交给 Server，否则整个 Server 都会退出。
+		if err != nil && !isTemporary(err) && !ml.retire(ln, err) {
+			return
+		}
+
+		select {
+		case ml.conns <- acceptResult{conn, err}:
+		case <-ml.closed:
+			if conn != nil {
+				conn.Close()
+			}
+
+			return
+		}
+
+		if err != nil && !isTemporary(err) {
+			return
+		}
+	}
+}
+
+// retire 停掉出错的 listener，返回 true 表示这是最后一个 listener，错误要交给 Server。
+func (ml *MultiListener) retire(ln net.Listener, err error) bool {
+	ml.mu.Lock()
+	ml.active--
+	last := ml.active == 0
+	ml.mu.Unlock()
+
+	select {
+	case <-ml.closed:
+		return last
+	default:
+	}
+
+	if !last {
+		log.Errorf("Server||listener stopped, others keep accepting||addr=%v||err=%v", ln.Addr(), err)
+		ln.Close()
+	}
+
+	return last
+}
+
+func (ml *MultiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-ml.conns:
+		return r.conn, r.err
+	case <-ml.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (ml *MultiListener) Close() error {
+	var err error
+
+	ml.closeOnce.Do(func() {
+		close(ml.closed)
+
+		for _, ln := range ml.listeners {
+			if e := ln.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+func (ml *MultiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
+
+// Listeners 返回合并之前的 listener。
+func (ml *MultiListener) Listeners() []net.Listener {
+	return ml.listeners
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// Registry 按服务名注册多个 thrift 服务，所有服务共用同一个端口。
+// 客户端使用 TMultiplexedProtocol 时按照 "服务名:方法名" 分发，
+// 没有带服务名的请求交给默认服务处理，这样原来不支持 multiplex 的客户端不需要任何修改。
+//
+// Registry 需要在服务启动之前注册完成，注册之后不能再修改。
+type Registry struct {
+	services    map[string]ContextProcessorFactory
+	defaultName string
+}
+
+// NewRegistry 创建一个空的 Registry。
+func NewRegistry() *Registry {
+	return &Registry{
+		services: map[string]ContextProcessorFactory{},
+	}
+}
+
+// Register 注册一个服务，name 不能为空、不能包含 ":"，也不能重复注册。
+func (r *Registry) Register(name string, factory ContextProcessorFactory) error {
+	if name == "" || strings.Contains(name, thrift.MULTIPLEXED_SEPARATOR) {
+		return fmt.Errorf("invalid service name %q", name)
+	}
+
+	if factory == nil {
+		return fmt.Errorf("processor factory of service %q is nil", name)
+	}
+
+	if _, ok := r.services[name]; ok {
+		return fmt.Errorf("service %q is already registered", name)
+	}
+
+	r.services[name] = factory
+	return nil
+}
+
+// RegisterDefault 注册一个服务并且把它设置为默认服务。
+func (r *Registry) RegisterDefault(name string, factory ContextProcessorFactory) error {
+	if r.defaultName != "" {
+		return fmt.Errorf("default service is already set to %q", r.defaultName)
+	}
+
+	if err := r.Register(name, factory); err != nil {
+		return err
+	}
+
+	r.defaultName = name
+	return nil
+}
+
+// Default 返回默认服务的名字，没有默认服务时返回空字符串。
+func (r *Registry) Default() string {
+	return r.defaultName
+}
+
+// Services 返回所有已注册的服务名，按字母排序。
+func (r *Registry) Services() []string {
+	names := make([]string, 0, len(r.services))
+
+	for name := range r.services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ProcessorFactory 返回给 Server 和 HTTP 接口使用的 processor factory，
+// 每个连接创建一个新的 TMultiplexedProcessor，各个服务的 processor 共用同一个 ctx。
+func (r *Registry) ProcessorFactory() ContextProcessorFactory {
+	return func(ctx context.Context) thrift.TProcessor {
+		processor := &multiplexedProcessor{
+			TMultiplexedProcessor: thrift.NewTMultiplexedProcessor(),
+			services:              map[string]bool{},
+		}
+
+		for name, factory := range r.services {
+			p := factory(ctx)
+			processor.RegisterProcessor(name, p)
+			processor.services[name] = true
+
+			if name == r.defaultName {
+				processor.RegisterDefault(p)
+			}
+		}
+
+		return processor
+	}
+}
+
+// multiplexedProcessor 在 TMultiplexedProcessor 的基础上处理未知服务名：
+// TMultiplexedProcessor 直接返回错误，不读取请求体也不回复，客户端只能等到超时。
+// 这里跳过请求体并且回复 UNKNOWN_METHOD，和生成代码处理未知方法的方式一致。
+type multiplexedProcessor struct {
+	*thrift.TMultiplexedProcessor
+	services map[string]bool
+}
+
+func (p *multiplexedProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, typeId, seqId, err := in.ReadMessageBegin()
+
+	if err != nil {
+		return false, err
+	}
+
+	v := strings.SplitN(name, thrift.MULTIPLEXED_SEPARATOR, 2)
+
+	if (len(v) == 2 && !p.services[v[0]]) || (len(v) != 2 && p.DefaultProcessor == nil) {
+		in.Skip(thrift.STRUCT)
+		in.ReadMessageEnd()
+		x := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown service of function "+name)
+		// 回复中的方法名不带服务名，和正常的 multiplex 回复一致。
+		out.WriteMessageBegin(v[len(v)-1], thrift.EXCEPTION, seqId)
+		x.Write(out)
+		out.WriteMessageEnd()
+		out.Flush()
+		return false, x
+	}
+
+	return p.TMultiplexedProcessor.Process(thrift.NewStoredMessageProtocol(in, name, typeId, seqId), out)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+func startTestRegistryServer(t *testing.T, registry *Registry) (*Server, string) {
+	transport, err := thrift.NewTServerSocket("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to create server socket. [err:%v]", err)
+	}
+
+	if err := transport.Listen(); err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	transportFactory, protocolFactory := thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault()
+	svr := NewServerFactory6(registry.ProcessorFactory(), transport, transportFactory, transportFactory, protocolFactory, protocolFactory, Options{})
+	go svr.Serve()
+
+	return svr, transport.Addr().String()
+}
+
+func newTestMultiplexedClient(t *testing.T, addr, service string) (*idl.Php_Go_SvrClient, thrift.TTransport) {
+	_, socket := newTestClient(t, addr)
+	protocol := thrift.NewTMultiplexedProtocol(thrift.NewTBinaryProtocolTransport(socket), service)
+	return idl.NewPhp_Go_SvrClientProtocol(socket, protocol, protocol), socket
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	newProcessor := func(ctx context.Context) thrift.TProcessor {
+		return idl.NewPhp_Go_SvrProcessor(testHandler{})
+	}
+
+	if err := registry.RegisterDefault("Php_Go_Svr", newProcessor); err != nil {
+		t.Fatalf("fail to register. [err:%v]", err)
+	}
+
+	if err := registry.Register("UserV2", newProcessor); err != nil {
+		t.Fatalf("fail to register. [err:%v]", err)
+	}
+
+	if err := registry.Register("UserV2", newProcessor); err == nil {
+		t.Fatalf("duplicated service must fail to register.")
+	}
+
+	if err := registry.Register("a:b", newProcessor); err == nil {
+		t.Fatalf("service name with separator must fail to register.")
+	}
+
+	svr, addr := startTestRegistryServer(t, registry)
+	defer svr.Stop()
+
+	// 原来的客户端不带服务名，由默认服务处理。
+	plain, plainSocket := newTestClient(t, addr)
+	defer plainSocket.Close()
+
+	if resp, err := plain.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.User.UserID != 1 {
+		t.Fatalf("fail to call default service. [resp:%v] [err:%v]", resp, err)
+	}
+
+	for _, name := range registry.Services() {
+		client, socket := newTestMultiplexedClient(t, addr, name)
+
+		if resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 2}); err != nil || resp.User.UserID != 2 {
+			t.Fatalf("fail to call service. [service:%v] [resp:%v] [err:%v]", name, resp, err)
+		}
+
+		socket.Close()
+	}
+
+	unknown, unknownSocket := newTestMultiplexedClient(t, addr, "Unknown")
+	defer unknownSocket.Close()
+	_, err := unknown.GetUserByUserID(&idl.GetUserByIdReq{UserID: 3})
+
+	if e, ok := err.(thrift.TApplicationException); !ok || e.TypeId() != thrift.UNKNOWN_METHOD {
+		t.Fatalf("unknown service must reply UNKNOWN_METHOD. [err:%v]", err)
+	}
+}
@@ -0,0 +1,520 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+// Server 的默认并发参数。
+const (
+	DefaultWorkers = 512
+	DefaultBacklog = 1024
+
+	acceptRetryMaxDelay  = time.Second
+	shutdownPollInterval = 20 * time.Millisecond
+)
+
+var (
+	// ErrServerBusy 表示连接数已达上限，新连接被拒绝。
+	ErrServerBusy = errors.New("server busy")
+)
+
+// Options 是 Server 的并发控制参数，零值表示使用默认值。
+type Options struct {
+	MaxConns int // 同时持有的最大连接数（包括正在处理的和排队中的），默认 Workers + Backlog。
+	Workers  int // 处理连接的 worker 数量，默认 DefaultWorkers。
+	Backlog  int // 已接受、等待 worker 的连接队列长度，默认 DefaultBacklog。
+
+	// Detect 为 true 时根据每个连接的前几个字节自动识别协议和 transport，
+	// 创建 Server 时传入的 transport 和 protocol factory 会被忽略。
+	Detect bool
+
+	// 连接的超时，为 0 表示不限制。IdleTimeout 是等待下一个请求的最长时间，
+	// ReadTimeout 是请求开始之后每次读数据的最长时间，WriteTimeout 是每次写数据的最长时间。
+	IdleTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// 解码时的大小限制，为 0 表示不限制。MaxFrameSize 只在 Detect 识别出 framed 时使用，
+	// 其他情况由创建 Server 时传入的 transport factory 决定。
+	MaxFrameSize     int
+	MaxMessageSize   int
+	MaxStringSize    int
+	MaxContainerSize int
+}
+
+func (opts Options) normalize() Options {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.Backlog < 0 {
+		opts.Backlog = 0
+	} else if opts.Backlog == 0 {
+		opts.Backlog = DefaultBacklog
+	}
+	if opts.MaxConns <= 0 {
+		opts.MaxConns = opts.Workers + opts.Backlog
+	}
+	return opts
+}
+
+// Server 是一个有界并发的 thrift server。
+// 与 TSimpleServer 每个连接一个 goroutine 不同，Server 使用固定数量的 worker 处理连接，
+// 已接受但还没有 worker 处理的连接进入 backlog 排队，连接数超过 MaxConns 或 backlog 已满时直接关闭新连接。
+type Server struct {
+	quit       chan struct{}
+	stopOnce   sync.Once
+	done       chan struct{}
+	serving    int32
+	inShutdown int32
+
+	processorFactory       thrift.TProcessorFactory
+	serverTransport        thrift.TServerTransport
+	inputTransportFactory  thrift.TTransportFactory
+	outputTransportFactory thrift.TTransportFactory
+	inputProtocolFactory   thrift.TProtocolFactory
+	outputProtocolFactory  thrift.TProtocolFactory
+
+	opts    Options
+	backlog chan thrift.TTransport
+	workers sync.WaitGroup
+
+	mu     sync.Mutex
+	active map[*conn]struct{}
+
+	conns      int64 // 当前持有的连接数。
+	accepted   int64
+	rejected   int64
+	violations Violations
+}
+
+// NewServer4 使用同一组 transport/protocol factory 创建 Server，参数与 thrift.NewTSimpleServer4 一致。
+func NewServer4(processor thrift.TProcessor, serverTransport thrift.TServerTransport, transportFactory thrift.TTransportFactory, protocolFactory thrift.TProtocolFactory, opts Options) *Server {
+	return NewServerFactory6(thrift.NewTProcessorFactory(processor),
+		serverTransport,
+		transportFactory,
+		transportFactory,
+		protocolFactory,
+		protocolFactory,
+		opts,
+	)
+}
+
+// NewServerFactory6 创建 Server。
+func NewServerFactory6(processorFactory thrift.TProcessorFactory, serverTransport thrift.TServerTransport, inputTransportFactory thrift.TTransportFactory, outputTransportFactory thrift.TTransportFactory, inputProtocolFactory thrift.TProtocolFactory, outputProtocolFactory thrift.TProtocolFactory, opts Options) *Server {
+	opts = opts.normalize()
+	return &Server{
+		quit:                   make(chan struct{}),
+		done:                   make(chan struct{}),
+		processorFactory:       processorFactory,
+		serverTransport:        serverTransport,
+		inputTransportFactory:  inputTransportFactory,
+		outputTransportFactory: outputTransportFactory,
+		inputProtocolFactory:   inputProtocolFactory,
+		outputProtocolFactory:  outputProtocolFactory,
+		opts:                   opts,
+		backlog:                make(chan thrift.TTransport, opts.Backlog),
+		active:                 make(map[*conn]struct{}),
+	}
+}
+
+func (s *Server) ProcessorFactory() thrift.TProcessorFactory {
+	return s.processorFactory
+}
+
+func (s *Server) ServerTransport() thrift.TServerTransport {
+	return s.serverTransport
+}
+
+func (s *Server) InputTransportFactory() thrift.TTransportFactory {
+	return s.inputTransportFactory
+}
+
+func (s *Server) OutputTransportFactory() thrift.TTransportFactory {
+	return s.outputTransportFactory
+}
+
+func (s *Server) InputProtocolFactory() thrift.TProtocolFactory {
+	return s.inputProtocolFactory
+}
+
+func (s *Server) OutputProtocolFactory() thrift.TProtocolFactory {
+	return s.outputProtocolFactory
+}
+
+// Options 返回生效的并发参数。
+func (s *Server) Options() Options {
+	return s.opts
+}
+
+// Conns 返回当前持有的连接数。
+func (s *Server) Conns() int64 {
+	return atomic.LoadInt64(&s.conns)
+}
+
+// Accepted 返回累计接受的连接数。
+func (s *Server) Accepted() int64 {
+	return atomic.LoadInt64(&s.accepted)
+}
+
+// Rejected 返回因为满载被拒绝的连接数。
+func (s *Server) Rejected() int64 {
+	return atomic.LoadInt64(&s.rejected)
+}
+
+// Violations 返回超时、消息过大等违规的累计次数。
+func (s *Server) Violations() Violations {
+	return s.violations.load()
+}
+
+// Accepting 返回 server 是否正在接受新连接，Serve 之前和 Stop、Shutdown 之后返回 false。
+func (s *Server) Accepting() bool {
+	if atomic.LoadInt32(&s.serving) == 0 {
+		return false
+	}
+
+	select {
+	case <-s.quit:
+		return false
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *Server) Listen() error {
+	return s.serverTransport.Listen()
+}
+
+// Serve 开始监听并处理连接，直到 Stop 被调用或者 accept 出现不可恢复的错误。
+func (s *Server) Serve() error {
+	atomic.StoreInt32(&s.serving, 1)
+	defer close(s.done)
+
+	if err := s.Listen(); err != nil {
+		return err
+	}
+
+	for i := 0; i < s.opts.Workers; i++ {
+		s.workers.Add(1)
+		go s.worker()
+	}
+
+	err := s.AcceptLoop()
+	close(s.backlog)
+	s.workers.Wait()
+	return err
+}
+
+func (s *Server) AcceptLoop() error {
+	var delay time.Duration
+
+	for {
+		client, err := s.serverTransport.Accept()
+
+		if err != nil {
+			select {
+			case <-s.quit:
+				return nil
+			default:
+			}
+
+			if !isTemporary(err) {
+				return err
+			}
+
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > acceptRetryMaxDelay {
+				delay = acceptRetryMaxDelay
+			}
+
+			log.Warnf("Server||accept error, retrying||delay=%v||err=%v", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+
+		delay = 0
+
+		if client == nil {
+			continue
+		}
+
+		atomic.AddInt64(&s.accepted, 1)
+
+		if atomic.AddInt64(&s.conns, 1) > int64(s.opts.MaxConns) {
+			s.reject(client)
+			continue
+		}
+
+		select {
+		case s.backlog <- client:
+		default:
+			s.reject(client)
+		}
+	}
+}
+
+// Stop 停止接受新连接。已经接受的连接会继续处理完。
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.serverTransport.Interrupt()
+	})
+	return nil
+}
+
+// Shutdown 优雅的关闭 server：停止接受新连接，关闭空闲连接，等待正在处理的请求结束。
+// 如果 ctx 先结束，所有连接都会被强制关闭，并返回 ctx.Err()。
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+	s.Stop()
+
+	if atomic.LoadInt32(&s.serving) == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.closeConns(false)
+
+		select {
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			s.closeConns(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// closeConns 关闭空闲连接，force 为 true 时关闭所有连接。
+func (s *Server) closeConns(force bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.active {
+		if force || !c.isBusy() {
+			c.forceClose()
+		}
+	}
+}
+
+func (s *Server) trackConn(c *conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		s.active[c] = struct{}{}
+	} else {
+		delete(s.active, c)
+	}
+}
+
+func (s *Server) reject(client thrift.TTransport) {
+	conns := atomic.AddInt64(&s.conns, -1)
+	atomic.AddInt64(&s.rejected, 1)
+	log.Warnf("Server||reject connection||peer=%v||conns=%v||err=%v", peerAddr(client), conns, ErrServerBusy)
+	client.Close()
+}
+
+func (s *Server) worker() {
+	defer s.workers.Done()
+
+	for client := range s.backlog {
+		peer := peerAddr(client)
+
+		if err := s.processRequests(client); err != nil {
+			log.Warnf("Server||error processing request||peer=%v||err=%v", peer, err)
+		}
+
+		atomic.AddInt64(&s.conns, -1)
+	}
+}
+
+func (s *Server) processRequests(client thrift.TTransport) error {
+	c := newConn(client, s.opts)
+	s.trackConn(c, true)
+	defer s.trackConn(c, false)
+	defer client.Close()
+
+	peer := peerAddr(client)
+
+	// 先完成 TLS 握手，这样创建 processor 的时候就可以拿到客户端证书。
+	if tlsConn, ok := c.netConn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+
+		if err := tlsConn.Handshake(); err != nil {
+			if isEOF(err) || c.isClosedByServer() {
+				return nil
+			}
+
+			return fmt.Errorf("tls handshake error: %v", err)
+		}
+
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	var base thrift.TTransport = c
+	inputTransportFactory, outputTransportFactory := s.inputTransportFactory, s.outputTransportFactory
+	inputProtocolFactory, outputProtocolFactory := s.inputProtocolFactory, s.outputProtocolFactory
+
+	if s.opts.Detect {
+		peeked := newPeekedTransport(c, 0)
+		d, err := detect(peeked.reader)
+
+		if err != nil {
+			return s.connError(c, peer, err)
+		}
+
+		log.Debugf("Server||protocol detected||peer=%v||protocol=%v", peer, d)
+		base = peeked
+		transportFactory, protocolFactory := d.factories(0, s.opts.MaxFrameSize)
+		inputTransportFactory, outputTransportFactory = transportFactory, transportFactory
+		inputProtocolFactory, outputProtocolFactory = protocolFactory, protocolFactory
+	}
+
+	processor := s.processorFactory.GetProcessor(c)
+	inputTransport := inputTransportFactory.GetTransport(base)
+	outputTransport := outputTransportFactory.GetTransport(base)
+	limitedInput := &limitedTransport{
+		TTransport:     inputTransport,
+		conn:           c,
+		maxMessageSize: int64(s.opts.MaxMessageSize),
+	}
+	inputProtocol := &limitedProtocol{
+		TProtocol:        inputProtocolFactory.GetProtocol(limitedInput),
+		trans:            limitedInput,
+		maxStringSize:    s.opts.MaxStringSize,
+		maxContainerSize: s.opts.MaxContainerSize,
+	}
+	outputProtocol := outputProtocolFactory.GetProtocol(outputTransport)
+
+	defer func() {
+		if e := recover(); e != nil {
+			log.Errorf("Server||panic in processor||peer=%v||err=%v||stack=%s", peer, e, debug.Stack())
+		}
+	}()
+
+	if inputTransport != nil {
+		defer inputTransport.Close()
+	}
+
+	if outputTransport != nil {
+		defer outputTransport.Close()
+	}
+
+	for {
+		ok, err := processor.Process(inputProtocol, outputProtocol)
+		c.finishRequest()
+
+		// 未知方法的请求体已经被跳过，并且回复了异常，连接可以继续使用。
+		if e, isApp := err.(thrift.TApplicationException); isApp && e.TypeId() == thrift.UNKNOWN_METHOD {
+			ok = true
+		}
+
+		// ok 为 true 时 processor 已经把 handler 返回的错误作为异常回复给客户端，连接可以继续使用；
+		// 否则说明读写出错，连接上的数据已经不完整。
+		if err != nil && !ok {
+			return s.connError(c, peer, err)
+		} else if err != nil {
+			log.Debugf("Server||handler error replied||peer=%v||err=%v", peer, err)
+		}
+
+		if !ok || s.shuttingDown() {
+			break
+		}
+	}
+
+	return nil
+}
+
+// connError 处理连接上的错误：客户端关闭连接和 server 主动关闭连接不算错误，
+// 超时和超过大小限制记录日志并计数，其余错误返回给调用方。
+func (s *Server) connError(c *conn, peer interface{}, err error) error {
+	if isEOF(err) || c.isClosedByServer() {
+		return nil
+	}
+
+	if c.violation == "" {
+		return err
+	}
+
+	s.violations.add(c.violation)
+
+	if c.violation == ViolationIdleTimeout {
+		log.Infof("Server||close idle connection||peer=%v||timeout=%v", peer, s.opts.IdleTimeout)
+		return nil
+	}
+
+	log.Warnf("Server||connection limit violated, closing||peer=%v||violation=%v||err=%v", peer, c.violation, err)
+	return nil
+}
+
+func peerAddr(client thrift.TTransport) interface{} {
+	if socket, ok := client.(*thrift.TSocket); ok && socket.Conn() != nil {
+		return socket.Conn().RemoteAddr()
+	}
+
+	return "-"
+}
+
+func isEOF(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+
+	if e, ok := err.(thrift.TTransportException); ok && e.TypeId() == thrift.END_OF_FILE {
+		return true
+	}
+
+	return false
+}
+
+func isTimeout(err error) bool {
+	if e, ok := err.(thrift.TTransportException); ok {
+		if e.TypeId() == thrift.TIMED_OUT {
+			return true
+		}
+
+		err = e.Err()
+	}
+
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+func isTemporary(err error) bool {
+	if e, ok := err.(thrift.TTransportException); ok {
+		err = e.Err()
+	}
+
+	type temporary interface {
+		Temporary() bool
+	}
+
+	if e, ok := err.(temporary); ok {
+		return e.Temporary()
+	}
+
+	return false
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+type testHandler struct {
+	delay time.Duration
+}
+
+func (testHandler) GetUserByUserID(req *idl.GetUserByIdReq) (*idl.GetUserByIdResp, error) {
+	return &idl.GetUserByIdResp{
+		Header: &idl.ResponseHeader{},
+		User:   &idl.UserInfo{UserID: req.UserID},
+	}, nil
+}
+
+func (h testHandler) SetUsers(req *idl.SetUsersReq) (*idl.SetUsersResp, error) {
+	time.Sleep(h.delay)
+	return &idl.SetUsersResp{Header: &idl.ResponseHeader{}}, nil
+}
+
+type errHandler struct {
+	testHandler
+}
+
+func (errHandler) SetUsers(req *idl.SetUsersReq) (*idl.SetUsersResp, error) {
+	return nil, errors.New("set users error")
+}
+
+func startTestServer(t *testing.T, handler idl.Php_Go_Svr, opts Options) (*Server, string) {
+	return startTestServerWithFactory(t, handler, thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault(), opts)
+}
+
+func startTestServerWithFactory(t *testing.T, handler idl.Php_Go_Svr, transportFactory thrift.TTransportFactory, protocolFactory thrift.TProtocolFactory, opts Options) (*Server, string) {
+	transport, err := thrift.NewTServerSocket("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to create server socket. [err:%v]", err)
+	}
+
+	if err := transport.Listen(); err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	processor := idl.NewPhp_Go_SvrProcessor(handler)
+	svr := NewServer4(processor, transport, transportFactory, protocolFactory, opts)
+	go svr.Serve()
+
+	return svr, transport.Addr().String()
+}
+
+func newTestClient(t *testing.T, addr string) (*idl.Php_Go_SvrClient, thrift.TTransport) {
+	socket, err := thrift.NewTSocketTimeout(addr, time.Second)
+
+	if err != nil {
+		t.Fatalf("fail to create socket. [err:%v]", err)
+	}
+
+	if err := socket.Open(); err != nil {
+		t.Fatalf("fail to open socket. [err:%v]", err)
+	}
+
+	return idl.NewPhp_Go_SvrClientFactory(socket, thrift.NewTBinaryProtocolFactoryDefault()), socket
+}
+
+func TestServerServe(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{}, Options{})
+	defer svr.Stop()
+
+	client, socket := newTestClient(t, addr)
+	defer socket.Close()
+
+	for i := int32(1); i <= 3; i++ {
+		resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: i})
+
+		if err != nil {
+			t.Fatalf("fail to call GetUserByUserID. [err:%v]", err)
+		}
+
+		if resp.User.UserID != i {
+			t.Fatalf("invalid user id. [expected:%v] [actual:%v]", i, resp.User.UserID)
+		}
+	}
+}
+
+func TestServerRejectWhenFull(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{}, Options{MaxConns: 1, Workers: 1, Backlog: 1})
+	defer svr.Stop()
+
+	client1, socket1 := newTestClient(t, addr)
+	defer socket1.Close()
+
+	if _, err := client1.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil {
+		t.Fatalf("fail to call GetUserByUserID. [err:%v]", err)
+	}
+
+	client2, socket2 := newTestClient(t, addr)
+	defer socket2.Close()
+
+	if _, err := client2.GetUserByUserID(&idl.GetUserByIdReq{UserID: 2}); err == nil {
+		t.Fatalf("connection over MaxConns must be rejected.")
+	}
+
+	if rejected := svr.Rejected(); rejected != 1 {
+		t.Fatalf("invalid rejected count. [expected:1] [actual:%v]", rejected)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	svr, addr := startTestServer(t, testHandler{delay: 200 * time.Millisecond}, Options{})
+
+	idleClient, idleSocket := newTestClient(t, addr)
+	defer idleSocket.Close()
+
+	if _, err := idleClient.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil {
+		t.Fatalf("fail to call GetUserByUserID. [err:%v]", err)
+	}
+
+	busyClient, busySocket := newTestClient(t, addr)
+	defer busySocket.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := busyClient.SetUsers(&idl.SetUsersReq{})
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatalf("fail to shutdown. [err:%v]", err)
+	}
+
+	if err := <-result; err != nil {
+		t.Fatalf("in-flight request must finish. [err:%v]", err)
+	}
+
+	if _, err := idleClient.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err == nil {
+		t.Fatalf("idle connection must be closed after shutdown.")
+	}
+
+	if conns := svr.Conns(); conns != 0 {
+		t.Fatalf("all connections must be released. [conns:%v]", conns)
+	}
+}
+
+func TestServerKeepConnOnHandlerError(t *testing.T) {
+	svr, addr := startTestServer(t, errHandler{}, Options{})
+	defer svr.Stop()
+
+	client, socket := newTestClient(t, addr)
+	defer socket.Close()
+
+	_, err := client.SetUsers(&idl.SetUsersReq{})
+
+	if e, ok := err.(thrift.TApplicationException); !ok || e.TypeId() != thrift.INTERNAL_ERROR {
+		t.Fatalf("handler error must be replied as INTERNAL_ERROR. [err:%v]", err)
+	}
+
+	if resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.User.UserID != 1 {
+		t.Fatalf("connection must be usable after handler error. [resp:%v] [err:%v]", resp, err)
+	}
+}
+
+func TestServerAccepting(t *testing.T) {
+	svr, _ := startTestServer(t, testHandler{}, Options{})
+
+	for i := 0; i < 100 && !svr.Accepting(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !svr.Accepting() {
+		t.Fatalf("server must be accepting after serve.")
+	}
+
+	svr.Stop()
+
+	if svr.Accepting() {
+		t.Fatalf("server must not be accepting after stop.")
+	}
+}
+
+type afterResponseHandler struct {
+	testHandler
+	ctx   context.Context
+	sizes chan [2]int64
+}
+
+func (h afterResponseHandler) SetUsers(req *idl.SetUsersReq) (*idl.SetUsersResp, error) {
+	start := RequestStart(h.ctx)
+	AfterResponse(h.ctx, func(requestBytes, responseBytes int64) {
+		// 没有记录请求开始的时间时让测试失败。
+		if start.IsZero() {
+			requestBytes = -1
+		}
+
+		h.sizes <- [2]int64{requestBytes, responseBytes}
+	})
+	return &idl.SetUsersResp{Header: &idl.ResponseHeader{}, UserIDs: make([]int32, len(req.UserInfoStr))}, nil
+}
+
+func TestAfterResponse(t *testing.T) {
+	transport, err := thrift.NewTServerSocket("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to create server socket. [err:%v]", err)
+	}
+
+	if err := transport.Listen(); err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	sizes := make(chan [2]int64, 2)
+	factory := ContextProcessorFactory(func(ctx context.Context) thrift.TProcessor {
+		return idl.NewPhp_Go_SvrProcessor(afterResponseHandler{ctx: ctx, sizes: sizes})
+	})
+	transportFactory, protocolFactory := thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault()
+	svr := NewServerFactory6(factory, transport, transportFactory, transportFactory, protocolFactory, protocolFactory, Options{})
+	go svr.Serve()
+	defer svr.Stop()
+
+	client, socket := newTestClient(t, transport.Addr().String())
+	defer socket.Close()
+
+	client.SetUsers(&idl.SetUsersReq{UserInfoStr: "a"})
+	client.SetUsers(&idl.SetUsersReq{UserInfoStr: "abcdefghijk"})
+	first, second := <-sizes, <-sizes
+
+	// 请求多 10 个字节的字符串，响应多 10 个 i32。
+	if first[0] <= 0 || second[0]-first[0] != 10 || second[1]-first[1] != 40 {
+		t.Fatalf("invalid request start or request and response bytes. [first:%v] [second:%v]", first, second)
+	}
+
+	if AfterResponse(context.Background(), func(int64, int64) {}) {
+		t.Fatalf("ctx without conn must not accept callback.")
+	}
+}
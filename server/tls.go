@@ -0,0 +1,229 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+// 客户端证书校验方式。
+const (
+	ClientAuthNone          = "none"           // 不要求客户端证书。
+	ClientAuthRequest       = "request"        // 客户端可以不提供证书，提供了就校验。
+	ClientAuthRequireVerify = "require_verify" // 客户端必须提供由 ClientCAFile 签发的证书。
+)
+
+const (
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// TLSOptions 是 TLS 的配置。
+type TLSOptions struct {
+	CertFile       string
+	KeyFile        string
+	MinVersion     string   // 1.0、1.1、1.2 或 1.3，默认 1.2。
+	CipherSuites   []string // 使用 crypto/tls 中的名字，比如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256，为空使用 Go 的默认值。
+	ClientAuth     string   // none、request 或 require_verify，默认 none。
+	ClientCAFile   string   // 校验客户端证书的 CA 证书文件，PEM 格式，可以包含多个证书。
+	ReloadInterval time.Duration
+}
+
+// TLSReloader 维护当前生效的 tls.Config。证书、私钥和 CA 文件变化之后调用 Reload 即可生效，
+// 已经建立的连接不受影响，新连接使用新的证书。
+type TLSReloader struct {
+	opts       TLSOptions
+	minVersion uint16
+	ciphers    []uint16
+	clientAuth tls.ClientAuthType
+
+	config  atomic.Value // *tls.Config
+	modTime atomic.Value // string
+}
+
+// NewTLSReloader 校验 opts 并且加载证书。
+func NewTLSReloader(opts TLSOptions) (*TLSReloader, error) {
+	r := &TLSReloader{
+		opts: opts,
+	}
+
+	var err error
+
+	if r.minVersion, err = parseTLSVersion(opts.MinVersion); err != nil {
+		return nil, err
+	}
+
+	if r.ciphers, err = parseCipherSuites(opts.CipherSuites); err != nil {
+		return nil, err
+	}
+
+	if r.clientAuth, err = parseClientAuth(opts.ClientAuth); err != nil {
+		return nil, err
+	}
+
+	if r.clientAuth != tls.NoClientCert && opts.ClientCAFile == "" {
+		return nil, fmt.Errorf("client CA file is required when client auth is %q", opts.ClientAuth)
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Config 返回给 tls.NewListener 使用的配置，每次握手都会使用最新加载的证书。
+func (r *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// Reload 重新加载证书、私钥和 CA 文件，加载失败时继续使用旧的配置。
+func (r *TLSReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+
+	if err != nil {
+		return fmt.Errorf("fail to load certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   r.minVersion,
+		CipherSuites: r.ciphers,
+		ClientAuth:   r.clientAuth,
+	}
+
+	if r.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.opts.ClientCAFile)
+
+		if err != nil {
+			return fmt.Errorf("fail to read client CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificate in client CA file %v", r.opts.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+	}
+
+	r.config.Store(config)
+	r.modTime.Store(r.filesModTime())
+	return nil
+}
+
+// Watch 每隔 ReloadInterval 检查一次文件修改时间，有变化就重新加载，直到 quit 被关闭。
+// ReloadInterval 为 0 时不检查，只能通过 Reload 手动加载。
+func (r *TLSReloader) Watch(quit <-chan struct{}) {
+	if r.opts.ReloadInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
+		if r.filesModTime() == r.modTime.Load().(string) {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Errorf("Server||fail to reload tls certificate||cert=%v||err=%v", r.opts.CertFile, err)
+			continue
+		}
+
+		log.Infof("Server||tls certificate reloaded||cert=%v", r.opts.CertFile)
+	}
+}
+
+func (r *TLSReloader) filesModTime() string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile}
+	times := make([]string, 0, len(files))
+
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		if stat, err := os.Stat(file); err == nil {
+			times = append(times, stat.ModTime().String())
+		}
+	}
+
+	return strings.Join(times, ",")
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("unsupported tls version %q", version)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := map[string]uint16{}
+
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+
+	for _, name := range names {
+		id, ok := suites[name]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func parseClientAuth(clientAuth string) (tls.ClientAuthType, error) {
+	switch clientAuth {
+	case "", ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequireVerify:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return 0, fmt.Errorf("unsupported client auth %q", clientAuth)
+}
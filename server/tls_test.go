@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("fail to generate key. [err:%v]", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signerCert, signerKey := template, key
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+
+	if err != nil {
+		t.Fatalf("fail to create certificate. [err:%v]", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+
+	if err != nil {
+		t.Fatalf("fail to marshal key. [err:%v]", err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLSClientIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+
+	if err != nil {
+		t.Fatalf("fail to create temp dir. [err:%v]", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test-ca", 1, nil, 0)
+	serverCert := newTestCert(t, "server", 2, ca, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, "php-client", 3, ca, x509.ExtKeyUsageClientAuth)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := serverCert.write(t, dir, "server")
+
+	reloader, err := NewTLSReloader(TLSOptions{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientAuth:   ClientAuthRequireVerify,
+		ClientCAFile: caFile,
+	})
+
+	if err != nil {
+		t.Fatalf("fail to create tls reloader. [err:%v]", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	identity := make(chan string, 1)
+	factory := ContextProcessorFactory(func(ctx context.Context) thrift.TProcessor {
+		identity <- ConnInfoFromContext(ctx).ClientIdentity()
+		return idl.NewPhp_Go_SvrProcessor(testHandler{})
+	})
+	transport := NewServerSocket(tls.NewListener(ln, reloader.Config()), 0)
+	transportFactory, protocolFactory := thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault()
+	svr := NewServerFactory6(factory, transport, transportFactory, transportFactory, protocolFactory, protocolFactory, Options{})
+	go svr.Serve()
+	defer svr.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert.tlsCertificate()},
+	}
+
+	socket, err := thrift.NewTSSLSocketTimeout(ln.Addr().String(), clientConfig, time.Second)
+
+	if err != nil {
+		t.Fatalf("fail to create socket. [err:%v]", err)
+	}
+
+	if err := socket.Open(); err != nil {
+		t.Fatalf("fail to open socket. [err:%v]", err)
+	}
+
+	defer socket.Close()
+	client := idl.NewPhp_Go_SvrClientFactory(socket, thrift.NewTBinaryProtocolFactoryDefault())
+
+	if _, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil {
+		t.Fatalf("fail to call GetUserByUserID. [err:%v]", err)
+	}
+
+	if id := <-identity; id != "php-client" {
+		t.Fatalf("invalid client identity. [expected:php-client] [actual:%v]", id)
+	}
+
+	// 没有客户端证书的连接必须被拒绝。
+	clientConfig.Certificates = nil
+	anonymous, _ := thrift.NewTSSLSocketTimeout(ln.Addr().String(), clientConfig, time.Second)
+
+	if err := anonymous.Open(); err == nil {
+		defer anonymous.Close()
+		client := idl.NewPhp_Go_SvrClientFactory(anonymous, thrift.NewTBinaryProtocolFactoryDefault())
+
+		if _, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err == nil {
+			t.Fatalf("connection without client certificate must be rejected.")
+		}
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+
+	if err != nil {
+		t.Fatalf("fail to create temp dir. [err:%v]", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test-ca", 1, nil, 0)
+	certFile, keyFile := newTestCert(t, "old", 2, ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	reloader, err := NewTLSReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+
+	if err != nil {
+		t.Fatalf("fail to create tls reloader. [err:%v]", err)
+	}
+
+	newTestCert(t, "new", 3, ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("fail to reload. [err:%v]", err)
+	}
+
+	config, _ := reloader.Config().GetConfigForClient(nil)
+	cert, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+
+	if cert.Subject.CommonName != "new" {
+		t.Fatalf("certificate is not reloaded. [actual:%v]", cert.Subject.CommonName)
+	}
+
+	// 加载失败时继续使用旧的证书。
+	ioutil.WriteFile(certFile, []byte("broken"), 0600)
+
+	if err := reloader.Reload(); err == nil {
+		t.Fatalf("broken certificate must fail to load.")
+	}
+
+	config, _ = reloader.Config().GetConfigForClient(nil)
+	cert, _ = x509.ParseCertificate(config.Certificates[0].Certificate[0])
+
+	if cert.Subject.CommonName != "new" {
+		t.Fatalf("last good certificate must be kept. [actual:%v]", cert.Subject.CommonName)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"git.xiaojukeji.com/soda-framework/go-log"
+)
+
+// DefaultUnixSocketMode 是 unix socket 文件的默认权限，同组用户可以连接。
+const DefaultUnixSocketMode os.FileMode = 0660
+
+// UnixOptions 是 unix socket 的参数。
+type UnixOptions struct {
+	Path  string
+	Mode  os.FileMode // 为 0 时使用 DefaultUnixSocketMode。
+	Owner string      // 用户名或者 uid，为空表示不修改。
+	Group string      // 组名或者 gid，为空表示不修改。
+}
+
+// ListenUnix 监听 unix socket。如果 Path 上有上次进程异常退出遗留的 socket 文件，先删除再监听；
+// 如果还有进程在这个 socket 上提供服务，或者 Path 不是 socket 文件，则返回错误。
+func ListenUnix(opts UnixOptions) (net.Listener, error) {
+	if opts.Path == "" {
+		return nil, errors.New("unix socket path is empty")
+	}
+
+	if err := removeStaleSocket(opts.Path); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", opts.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mode := opts.Mode
+
+	if mode == 0 {
+		mode = DefaultUnixSocketMode
+	}
+
+	if err := os.Chmod(opts.Path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("fail to chmod unix socket: %v", err)
+	}
+
+	if err := chownSocket(opts.Path, opts.Owner, opts.Group); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+func removeStaleSocket(path string) error {
+	stat, err := os.Lstat(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if stat.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%v exists and is not a unix socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %v is in use by another process", path)
+	}
+
+	log.Warnf("Server||remove stale unix socket||path=%v||err=%v", path, err)
+	return os.Remove(path)
+}
+
+func chownSocket(path, owner, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if owner != "" {
+		id, err := lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("unknown unix socket owner %q: %v", owner, err)
+		}
+
+		uid = id
+	}
+
+	if group != "" {
+		id, err := lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("unknown unix socket group %q: %v", group, err)
+		}
+
+		gid = id
+	}
+
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("fail to chown unix socket: %v", err)
+	}
+
+	return nil
+}
+
+// lookupID 把名字转换成数字 id，名字本身是数字时直接使用。
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
+)
+
+func TestUnixListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+
+	if err != nil {
+		t.Fatalf("fail to create temp dir. [err:%v]", err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "server.sock")
+
+	// 模拟上次进程异常退出遗留的 socket 文件。
+	stale, err := net.Listen("unix", path)
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	unixLn, err := ListenUnix(UnixOptions{Path: path, Mode: 0600})
+
+	if err != nil {
+		t.Fatalf("fail to listen unix socket. [err:%v]", err)
+	}
+
+	if stat, err := os.Stat(path); err != nil || stat.Mode().Perm() != 0600 {
+		t.Fatalf("invalid socket file mode. [stat:%v] [err:%v]", stat, err)
+	}
+
+	if _, err := ListenUnix(UnixOptions{Path: path}); err == nil {
+		t.Fatalf("socket in use must not be removed.")
+	}
+
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	transport := NewServerSocket(NewMultiListener(tcpLn, unixLn), 0)
+	processor := idl.NewPhp_Go_SvrProcessor(testHandler{})
+	svr := NewServer4(processor, transport, thrift.NewTTransportFactory(), thrift.NewTBinaryProtocolFactoryDefault(), Options{})
+	go svr.Serve()
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+
+	if err != nil {
+		t.Fatalf("fail to dial unix socket. [err:%v]", err)
+	}
+
+	socket := thrift.NewTSocketFromConnTimeout(conn, time.Second)
+	client := idl.NewPhp_Go_SvrClientFactory(socket, thrift.NewTBinaryProtocolFactoryDefault())
+
+	if resp, err := client.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1}); err != nil || resp.User.UserID != 1 {
+		t.Fatalf("fail to call over unix socket. [resp:%v] [err:%v]", resp, err)
+	}
+
+	socket.Close()
+	tcpClient, tcpSocket := newTestClient(t, tcpLn.Addr().String())
+
+	if _, err := tcpClient.GetUserByUserID(&idl.GetUserByIdReq{UserID: 2}); err != nil {
+		t.Fatalf("fail to call over tcp. [err:%v]", err)
+	}
+
+	tcpSocket.Close()
+	svr.Stop()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file must be removed after stop. [err:%v]", err)
+	}
+}
+
+// brokenListener 模拟已经不能用的 listener，比如 socket 文件被删掉的 unix socket。
+type brokenListener struct {
+	net.Listener
+}
+
+func (brokenListener) Accept() (net.Conn, error) {
+	return nil, errors.New("broken listener")
+}
+
+func (brokenListener) Close() error {
+	return nil
+}
+
+func TestMultiListenerRetiresBrokenListener(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("fail to listen. [err:%v]", err)
+	}
+
+	ml := NewMultiListener(tcpLn, brokenListener{tcpLn})
+	defer ml.Close()
+
+	// 一个 listener 出错之后其他 listener 继续 Accept。
+	for i := 0; i < 2; i++ {
+		conn, err := net.DialTimeout("tcp", tcpLn.Addr().String(), time.Second)
+
+		if err != nil {
+			t.Fatalf("fail to dial. [err:%v]", err)
+		}
+
+		accepted, err := ml.Accept()
+
+		if err != nil {
+			t.Fatalf("broken listener must not stop others. [err:%v]", err)
+		}
+
+		accepted.Close()
+		conn.Close()
+	}
+
+	// 所有 listener 都停掉之后 Accept 返回错误。
+	tcpLn.Close()
+
+	if _, err := ml.Accept(); err == nil {
+		t.Fatalf("accept must fail after all listeners stopped.")
+	}
+}
@@ -0,0 +1,15 @@
+package service
+
+//ResponseHeader.Code 的取值，PHP 端按照 code 判断请求是否成功
+const (
+	CodeOK			= 0
+	CodeRedisError		= 1	//读写 Redis 失败
+	CodeUnmarshalUserError	= 2	//Redis 中的用户数据格式错误
+	CodeUnmarshalUsersError	= 3	//请求中的 userInfoStr 格式错误
+	CodeDeadlineExceeded	= 4	//调用方的超时时间已经用完，PHP 端已经放弃了这个请求
+	CodeUnauthenticated	= 5	//调用方签名校验失败，或者服务端要求签名但是请求没有带
+	CodePermissionDenied	= 6	//调用方的角色不允许调用这个方法
+	CodeRateLimited		= 7	//调用方超过了限流规则，ResponseHeader.retryAfterMs 之后再重试
+	CodeOverloaded		= 8	//服务端正在处理的请求超过了自适应的并发上限
+	CodeRedisUnavailable	= 9	//Redis 熔断器打开，没有访问 Redis，稍后再重试
+)
@@ -1,64 +1,105 @@
 package service
 
 import (
+	"context"
 	"git.xiaojukeji.com/soda-framework/go-log"
+	"git.xiaojukeji.com/soda-framework/go-trace"
 	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
 	"php-thrift-go-server/rpc"
+	"php-thrift-go-server/timing"
 	"php-thrift-go-server/util"
 	"strconv"
 )
 
+// Name 是 Php_Go_Svr 在 multiplex 时使用的服务名。
+const Name = "Php_Go_Svr"
+
 type Service struct {
+	ctx context.Context
 }
 
 func New() *Service {
-	return &Service{}
+	return NewWithContext(context.Background())
+}
+
+// NewWithContext 创建一个绑定到 ctx 的 Service，ctx 中带有 server.ConnInfo 和调用方的 trace 信息。
+func NewWithContext(ctx context.Context) *Service {
+	return &Service{ctx: ctx}
 }
 
-func(*Service) GetUserByUserID(req *idl.GetUserByIdReq)(resp *idl.GetUserByIdResp, err error){
-	log.Infof("Service||GetUserByUserID||req=%v", util.JsonString(req))
+func(s *Service) GetUserByUserID(req *idl.GetUserByIdReq)(resp *idl.GetUserByIdResp, err error){
 	resp = &idl.GetUserByIdResp{
 		Header:&idl.ResponseHeader{},
 		User:&idl.UserInfo{},
 	}
-	val, err := rpc.RedisGet(strconv.FormatInt(int64(req.UserID), 10))
+	val, err := rpc.RedisGet(s.ctx, strconv.FormatInt(int64(req.UserID), 10))
+	if err == rpc.ErrDeadlineExceeded {
+		resp.Header.Code = CodeDeadlineExceeded
+		resp.Header.Msg = "deadline exceeded"
+		return resp, nil
+	}
+	if err == rpc.ErrCircuitOpen {
+		resp.Header.Code = CodeRedisUnavailable
+		resp.Header.Msg = "redis unavailable"
+		return resp, nil
+	}
 	if err != nil {
-		resp.Header.Code = 1
+		resp.Header.Code = CodeRedisError
 		resp.Header.Msg = "get value from redis error"
-		log.Errorf("Service||GetUserByUserID||redis internal error||userID=%d", req.UserID)
+		log.Errorf("Service||GetUserByUserID||%v||redis internal error||userID=%d", trace.ContextString(s.ctx), req.UserID)
 		return
 	}
 	user := idl.UserInfo{}
+	stop := timing.Start(s.ctx, "json_unmarshal")
 	err = util.JsonUnmarshalFromString(val, &user)
+	stop()
 	if err != nil {
-		resp.Header.Code = 2
+		resp.Header.Code = CodeUnmarshalUserError
 		resp.Header.Msg = "util.JsonUnmarshalFromString error"
-		log.Errorf("Service||GetUserByUserID||util.JsonUnmarshalFromString error||user=%d", val)
+		log.Errorf("Service||GetUserByUserID||%v||util.JsonUnmarshalFromString error||user=%v", trace.ContextString(s.ctx), val)
 		return
 	}
-	resp.Header.Code = 0
+	resp.Header.Code = CodeOK
 	resp.User = &user
 	return
 }
 
-func(*Service) SetUsers(req *idl.SetUsersReq)(resp *idl.SetUsersResp, err error){
-	log.Infof("Service||SetUsers||req=%v", util.JsonString(req))
+func(s *Service) SetUsers(req *idl.SetUsersReq)(resp *idl.SetUsersResp, err error){
 	resp = &idl.SetUsersResp{
 		Header:&idl.ResponseHeader{},
 		UserIDs:[]int32{},
 	}
 	users := []idl.UserInfo{}
+	stop := timing.Start(s.ctx, "json_unmarshal")
 	err = util.JsonUnmarshalFromString(req.UserInfoStr, &users)
+	stop()
 	if err != nil {
-		resp.Header.Code = 3
+		resp.Header.Code = CodeUnmarshalUsersError
 		resp.Header.Msg = "JsonUnmarshalFromString error"
-		log.Errorf("Service||SetUsers||util.JsonUnmarshalFromString error||users=%v", req.UserInfoStr)
+		log.Errorf("Service||SetUsers||%v||util.JsonUnmarshalFromString error||users=%v", trace.ContextString(s.ctx), req.UserInfoStr)
 		return
 	}
 	for _, user := range users {
-		rpc.RedisSet(strconv.FormatInt(int64(user.UserID), 10), user)
+		//超时、熔断或者写入失败之后不再继续写，UserIDs 中是已经写入的用户
+		err := rpc.RedisSet(s.ctx, strconv.FormatInt(int64(user.UserID), 10), user)
+		if err == rpc.ErrDeadlineExceeded {
+			resp.Header.Code = CodeDeadlineExceeded
+			resp.Header.Msg = "deadline exceeded"
+			return resp, nil
+		}
+		if err == rpc.ErrCircuitOpen {
+			resp.Header.Code = CodeRedisUnavailable
+			resp.Header.Msg = "redis unavailable"
+			return resp, nil
+		}
+		if err != nil {
+			resp.Header.Code = CodeRedisError
+			resp.Header.Msg = "set value to redis error"
+			log.Errorf("Service||SetUsers||%v||redis internal error||userID=%d||err=%v", trace.ContextString(s.ctx), user.UserID, err)
+			return resp, nil
+		}
 		resp.UserIDs = append(resp.UserIDs, user.UserID)
 	}
-	resp.Header.Code = 0
+	resp.Header.Code = CodeOK
 	return
 }
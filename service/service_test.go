@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"git.xiaojukeji.com/soda-framework/go-log"
+	"github.com/go-redis/redis"
 	"github.com/yingongzi/php-thrift/gen-go/php_go/idl"
 	"php-thrift-go-server/client"
 	"php-thrift-go-server/conf"
@@ -50,3 +52,31 @@ func TestService_SetUsers(t *testing.T) {
 	fmt.Println(util.JsonString(resp2), "=======", err)
 
 }
+
+func TestService_DeadlineExceeded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svr := NewWithContext(ctx)
+
+	resp, err := svr.GetUserByUserID(&idl.GetUserByIdReq{UserID: 1})
+	if err != nil || resp.Header.Code != CodeDeadlineExceeded {
+		t.Fatalf("expired request must not read redis. [resp:%v] [err:%v]", util.JsonString(resp), err)
+	}
+
+	resp2, err := svr.SetUsers(&idl.SetUsersReq{UserInfoStr: `[{"userID":1}]`})
+	if err != nil || resp2.Header.Code != CodeDeadlineExceeded || len(resp2.UserIDs) != 0 {
+		t.Fatalf("expired request must not write redis. [resp:%v] [err:%v]", util.JsonString(resp2), err)
+	}
+}
+
+func TestService_SetUsersRedisError(t *testing.T) {
+	//连接不上的 Redis，写入失败不能当作成功返回
+	client.RedisClient = *redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.RedisClient.Close()
+	svr := New()
+
+	resp, err := svr.SetUsers(&idl.SetUsersReq{UserInfoStr: `[{"userID":1},{"userID":2}]`})
+	if err != nil || resp.Header.Code != CodeRedisError || len(resp.UserIDs) != 0 {
+		t.Fatalf("failed write must not be reported as success. [resp:%v] [err:%v]", util.JsonString(resp), err)
+	}
+}
@@ -0,0 +1,97 @@
+// Package timing 记录一次调用中各个阶段的耗时，比如解码请求、每个 Redis 命令、JSON 序列化，
+// 用于慢日志和 debug 模式下返回给调用方的耗时分解。
+package timing
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type recorderOfContext struct{}
+
+var (
+	keyRecorder = recorderOfContext{}
+)
+
+// Phase 是一个阶段的名字和耗时，同名的阶段可以出现多次，比如 SetUsers 中的每个 Redis 命令。
+type Phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Recorder 按顺序保存一次调用中各个阶段的耗时，可以在多个 goroutine 中使用。
+// nil 的 Recorder 不记录任何数据，没有开启慢日志时业务代码不需要判断。
+type Recorder struct {
+	mu     sync.Mutex
+	phases []Phase
+}
+
+// NewContext 返回一个带有新 Recorder 的 ctx。
+func NewContext(ctx context.Context) (context.Context, *Recorder) {
+	r := &Recorder{}
+	return context.WithValue(ctx, keyRecorder, r), r
+}
+
+// FromContext 返回 ctx 中的 Recorder，如果不存在则返回 nil。
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(keyRecorder).(*Recorder)
+	return r
+}
+
+// Start 开始记录 ctx 中名为 name 的阶段，返回的函数在阶段结束时调用，一般写成
+// defer timing.Start(ctx, "redis.get")()。
+func Start(ctx context.Context, name string) func() {
+	r := FromContext(ctx)
+
+	if r == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		r.Add(name, time.Since(start))
+	}
+}
+
+// Add 记录一个阶段的耗时。
+func (r *Recorder) Add(name string, d time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	r.phases = append(r.phases, Phase{Name: name, Duration: d})
+	r.mu.Unlock()
+}
+
+// Phases 按记录的顺序返回所有阶段。
+func (r *Recorder) Phases() []Phase {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Phase(nil), r.phases...)
+}
+
+// String 返回 "decode=0.052ms,redis.get=1.203ms" 格式的耗时分解，单位是毫秒。
+func (r *Recorder) String() string {
+	buf := &bytes.Buffer{}
+
+	for i, p := range r.Phases() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		buf.WriteString(p.Name)
+		buf.WriteByte('=')
+		buf.WriteString(strconv.FormatFloat(float64(p.Duration.Nanoseconds())/1e6, 'f', 3, 64))
+		buf.WriteString("ms")
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,33 @@
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	// 没有 Recorder 时不记录，也不能 panic。
+	Start(context.Background(), "redis.get")()
+
+	ctx, r := NewContext(context.Background())
+
+	if FromContext(ctx) != r {
+		t.Fatalf("recorder must be stored in ctx.")
+	}
+
+	r.Add("decode", 52*time.Microsecond)
+	r.Add("redis.set", 1203*time.Microsecond)
+	r.Add("redis.set", 2*time.Millisecond)
+	Start(ctx, "json_unmarshal")()
+
+	if phases := r.Phases(); len(phases) != 4 || phases[3].Name != "json_unmarshal" {
+		t.Fatalf("phases must be recorded in order. [actual:%v]", phases)
+	}
+
+	r.phases = r.phases[:3]
+
+	if expected, actual := "decode=0.052ms,redis.set=1.203ms,redis.set=2.000ms", r.String(); actual != expected {
+		t.Fatalf("invalid string. [expected:%v] [actual:%v]", expected, actual)
+	}
+}